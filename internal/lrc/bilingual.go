@@ -0,0 +1,71 @@
+package lrc
+
+// DefaultAlignToleranceMS is how far apart two lines may be and still be
+// treated as the same line when aligning tracks.
+const DefaultAlignToleranceMS = 300
+
+// Align returns, for every line of base, the text of the line in other that
+// starts at (nearly) the same time, or "" when there is none.
+// Both documents must be sorted by time, as Parse leaves them.
+func Align(base, other Document, toleranceMS int64) []string {
+	out := make([]string, len(base.Lines))
+	j := 0
+	for i, l := range base.Lines {
+		for j < len(other.Lines) && other.Lines[j].TimeMS < l.TimeMS-toleranceMS {
+			j++
+		}
+		best := -1
+		for k := j; k < len(other.Lines) && other.Lines[k].TimeMS <= l.TimeMS+toleranceMS; k++ {
+			if best < 0 || abs(other.Lines[k].TimeMS-l.TimeMS) < abs(other.Lines[best].TimeMS-l.TimeMS) {
+				best = k
+			}
+		}
+		if best >= 0 {
+			out[i] = other.Lines[best].Text
+			// 每行只匹配一次
+			j = best + 1
+		}
+	}
+	return out
+}
+
+// SplitBilingual splits the common "translation under the same timestamp"
+// convention: for lines sharing a timestamp, the first occurrence goes to
+// the original and the second to the translation.
+func SplitBilingual(doc Document) (Document, Document) {
+	orig := Document{Meta: doc.Meta, OffsetMS: doc.OffsetMS, Lines: []Line{}, Errors: doc.Errors}
+	trans := Document{Meta: map[string]string{}, Lines: []Line{}, Errors: []ParseError{}}
+
+	for i := 0; i < len(doc.Lines); i++ {
+		l := doc.Lines[i]
+		orig.Lines = append(orig.Lines, l)
+		if i+1 < len(doc.Lines) && doc.Lines[i+1].TimeMS == l.TimeMS {
+			trans.Lines = append(trans.Lines, Line{TimeMS: l.TimeMS, Text: doc.Lines[i+1].Text})
+			i++
+		}
+	}
+	orig.finalise()
+	trans.finalise()
+	return orig, trans
+}
+
+// JoinBilingual writes base with the aligned lines of other placed under
+// the same timestamp, the reverse of SplitBilingual.
+func JoinBilingual(base, other Document) Document {
+	aligned := Align(base, other, DefaultAlignToleranceMS)
+	out := Document{Meta: base.Meta, Lines: make([]Line, 0, len(base.Lines)*2)}
+	for i, l := range base.Lines {
+		out.Lines = append(out.Lines, l)
+		if aligned[i] != "" {
+			out.Lines = append(out.Lines, Line{TimeMS: l.TimeMS, Text: aligned[i]})
+		}
+	}
+	return out
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,289 @@
+// Package lrc parses and formats LRC lyrics, including multi-timestamp
+// lines, [offset:] tags, metadata tags and enhanced (word-level) timing.
+package lrc
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Word is a word-level timed fragment from enhanced LRC (<mm:ss.xx>word).
+type Word struct {
+	StartMS int64  `json:"startMs"`
+	EndMS   int64  `json:"endMs"` // 下一个词（或下一行）的开始时间，0 表示未知
+	Text    string `json:"text"`
+}
+
+// Line is a single timed lyric line.
+type Line struct {
+	TimeMS int64  `json:"timeMs"`
+	EndMS  int64  `json:"endMs"` // 下一行的开始时间，0 表示直到结尾
+	Text   string `json:"text"`
+	Words  []Word `json:"words,omitempty"`
+}
+
+// ParseError reports a problem on a given (1-based) source line.
+type ParseError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// Document is a parsed LRC file. Times in Lines are already adjusted by the
+// [offset:] tag; OffsetMS keeps the original tag value for reference.
+type Document struct {
+	Meta     map[string]string `json:"meta"`
+	OffsetMS int               `json:"offsetMs"`
+	Lines    []Line            `json:"lines"`
+	Errors   []ParseError      `json:"errors"`
+}
+
+var (
+	// [mm:ss], [mm:ss.xx], [mm:ss.xxx], [mm:ss:xx]
+	timeTagRe = regexp.MustCompile(`^\[(\d+):(\d{1,2})(?:[.:](\d{1,3}))?\]`)
+	// [key:value]
+	metaTagRe = regexp.MustCompile(`^\[([A-Za-z#]+):([^\]]*)\]\s*$`)
+	// <mm:ss.xx>
+	wordTagRe = regexp.MustCompile(`<(\d+):(\d{1,2})(?:[.:](\d{1,3}))?>`)
+)
+
+// metaOrder is the order in which known metadata tags are written back.
+var metaOrder = []string{"ti", "ar", "al", "au", "by", "length", "re", "ve"}
+
+// Parse parses LRC text. Invalid lines are skipped and reported in Errors.
+func Parse(text string) Document {
+	doc := Document{Meta: map[string]string{}, Lines: []Line{}, Errors: []ParseError{}}
+	text = strings.TrimPrefix(text, "\ufeff")
+
+	for i, raw := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		lineNo := i + 1
+		line := strings.TrimSpace(raw)
+		if line == "" {
+			continue
+		}
+
+		if m := metaTagRe.FindStringSubmatch(line); m != nil && !timeTagRe.MatchString(line) {
+			key := strings.ToLower(m[1])
+			val := strings.TrimSpace(m[2])
+			if key == "offset" {
+				off, err := strconv.Atoi(strings.TrimPrefix(val, "+"))
+				if err != nil {
+					doc.Errors = append(doc.Errors, ParseError{Line: lineNo, Message: fmt.Sprintf("invalid offset %q", val)})
+					continue
+				}
+				doc.OffsetMS = off
+				continue
+			}
+			doc.Meta[key] = val
+			continue
+		}
+
+		var times []int64
+		rest := line
+		bad := false
+		for {
+			m := timeTagRe.FindStringSubmatch(rest)
+			if m == nil {
+				break
+			}
+			t, err := clockToMS(m[1], m[2], m[3])
+			if err != nil {
+				doc.Errors = append(doc.Errors, ParseError{Line: lineNo, Message: err.Error()})
+				bad = true
+				break
+			}
+			times = append(times, t)
+			rest = rest[len(m[0]):]
+		}
+		if bad {
+			continue
+		}
+		if len(times) == 0 {
+			doc.Errors = append(doc.Errors, ParseError{Line: lineNo, Message: "missing timestamp"})
+			continue
+		}
+
+		text, words, err := parseWords(rest)
+		if err != nil {
+			doc.Errors = append(doc.Errors, ParseError{Line: lineNo, Message: err.Error()})
+			continue
+		}
+		for _, t := range times {
+			l := Line{TimeMS: t, Text: text}
+			if len(words) > 0 {
+				l.Words = append([]Word(nil), words...)
+			}
+			doc.Lines = append(doc.Lines, l)
+		}
+	}
+
+	// [offset:] 正值表示歌词提前显示
+	if doc.OffsetMS != 0 {
+		doc.Shift(-int64(doc.OffsetMS))
+	}
+	doc.finalise()
+	return doc
+}
+
+// parseWords extracts enhanced word timing from the text after the line tags.
+func parseWords(s string) (string, []Word, error) {
+	locs := wordTagRe.FindAllStringSubmatchIndex(s, -1)
+	if len(locs) == 0 {
+		return strings.TrimSpace(s), nil, nil
+	}
+
+	var (
+		words []Word
+		plain strings.Builder
+	)
+	plain.WriteString(s[:locs[0][0]])
+	for i, loc := range locs {
+		t, err := clockToMS(s[loc[2]:loc[3]], s[loc[4]:loc[5]], optionalGroup(s, loc[6], loc[7]))
+		if err != nil {
+			return "", nil, err
+		}
+		end := len(s)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		fragment := s[loc[1]:end]
+		plain.WriteString(fragment)
+		if len(words) > 0 && words[len(words)-1].EndMS == 0 {
+			words[len(words)-1].EndMS = t
+		}
+		if fragment == "" {
+			// 行尾的结束标记 <mm:ss.xx>
+			continue
+		}
+		words = append(words, Word{StartMS: t, Text: fragment})
+	}
+	return strings.TrimSpace(plain.String()), words, nil
+}
+
+func optionalGroup(s string, start, end int) string {
+	if start < 0 {
+		return ""
+	}
+	return s[start:end]
+}
+
+func clockToMS(min, sec, frac string) (int64, error) {
+	m, err := strconv.ParseInt(min, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid minutes %q", min)
+	}
+	s, err := strconv.ParseInt(sec, 10, 64)
+	if err != nil || s >= 60 {
+		return 0, fmt.Errorf("invalid seconds %q", sec)
+	}
+	var ms int64
+	if frac != "" {
+		f, err := strconv.ParseInt(frac, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid fraction %q", frac)
+		}
+		switch len(frac) {
+		case 1:
+			ms = f * 100
+		case 2:
+			ms = f * 10
+		default:
+			ms = f
+		}
+	}
+	return m*60000 + s*1000 + ms, nil
+}
+
+// Shift moves every line and word by delta milliseconds (clamped at 0).
+func (d *Document) Shift(delta int64) {
+	shift := func(t int64) int64 {
+		return max(t+delta, 0)
+	}
+	for i := range d.Lines {
+		d.Lines[i].TimeMS = shift(d.Lines[i].TimeMS)
+		if d.Lines[i].EndMS != 0 {
+			d.Lines[i].EndMS = shift(d.Lines[i].EndMS)
+		}
+		for j := range d.Lines[i].Words {
+			w := &d.Lines[i].Words[j]
+			w.StartMS = shift(w.StartMS)
+			if w.EndMS != 0 {
+				w.EndMS = shift(w.EndMS)
+			}
+		}
+	}
+}
+
+// finalise sorts lines by time and fills line/word end times.
+func (d *Document) finalise() {
+	sort.SliceStable(d.Lines, func(i, j int) bool { return d.Lines[i].TimeMS < d.Lines[j].TimeMS })
+	for i := range d.Lines {
+		var next int64
+		if i+1 < len(d.Lines) {
+			next = d.Lines[i+1].TimeMS
+		}
+		d.Lines[i].EndMS = next
+		if n := len(d.Lines[i].Words); n > 0 && d.Lines[i].Words[n-1].EndMS == 0 {
+			d.Lines[i].Words[n-1].EndMS = next
+		}
+	}
+}
+
+// Format serialises a document to clean LRC: known metadata first, one
+// timestamp per line in time order, enhanced word timing preserved.
+// Line times are written as-is, so no [offset:] tag is emitted.
+func Format(d Document) string {
+	var b strings.Builder
+
+	written := map[string]bool{"offset": true}
+	for _, key := range metaOrder {
+		if v, ok := d.Meta[key]; ok && v != "" {
+			fmt.Fprintf(&b, "[%s:%s]\n", key, v)
+		}
+		written[key] = true
+	}
+	var extra []string
+	for key := range d.Meta {
+		if !written[key] {
+			extra = append(extra, key)
+		}
+	}
+	sort.Strings(extra)
+	for _, key := range extra {
+		fmt.Fprintf(&b, "[%s:%s]\n", key, d.Meta[key])
+	}
+
+	lines := append([]Line(nil), d.Lines...)
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].TimeMS < lines[j].TimeMS })
+	for _, l := range lines {
+		b.WriteString("[" + FormatTime(l.TimeMS) + "]")
+		if len(l.Words) == 0 {
+			b.WriteString(l.Text)
+		} else {
+			for i, w := range l.Words {
+				b.WriteString("<" + FormatTime(w.StartMS) + ">" + w.Text)
+				last := i == len(l.Words)-1
+				if last && w.EndMS != 0 && w.EndMS != l.EndMS {
+					b.WriteString("<" + FormatTime(w.EndMS) + ">")
+				}
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// FormatTime renders milliseconds as mm:ss.xx.
+func FormatTime(ms int64) string {
+	if ms < 0 {
+		ms = 0
+	}
+	cs := (ms + 5) / 10
+	return fmt.Sprintf("%02d:%02d.%02d", cs/6000, (cs/100)%60, cs%100)
+}
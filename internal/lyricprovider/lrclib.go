@@ -0,0 +1,109 @@
+package lyricprovider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// LRCLibName is the registry key of the LRCLIB provider.
+const LRCLibName = "lrclib"
+
+const lrclibDefaultBaseURL = "https://lrclib.net"
+
+// lrclib implements Provider for https://lrclib.net.
+type lrclib struct {
+	client  *http.Client
+	baseURL string
+}
+
+// NewLRCLib builds an LRCLIB provider.
+func NewLRCLib(client *http.Client, cfg Config) Provider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	base := strings.TrimRight(cfg.BaseURL, "/")
+	if base == "" {
+		base = lrclibDefaultBaseURL
+	}
+	return &lrclib{client: client, baseURL: base}
+}
+
+func (p *lrclib) Name() string { return LRCLibName }
+
+type lrclibRecord struct {
+	ID           int64   `json:"id"`
+	TrackName    string  `json:"trackName"`
+	ArtistName   string  `json:"artistName"`
+	AlbumName    string  `json:"albumName"`
+	Duration     float64 `json:"duration"`
+	PlainLyrics  string  `json:"plainLyrics"`
+	SyncedLyrics string  `json:"syncedLyrics"`
+}
+
+func (p *lrclib) Search(ctx context.Context, q Query) ([]Candidate, error) {
+	params := url.Values{}
+	params.Set("track_name", q.Title)
+	if q.Artist != "" {
+		params.Set("artist_name", q.Artist)
+	}
+	var recs []lrclibRecord
+	if err := p.getJSON(ctx, "/api/search?"+params.Encode(), &recs); err != nil {
+		return nil, err
+	}
+
+	out := make([]Candidate, 0, len(recs))
+	for _, r := range recs {
+		if r.SyncedLyrics == "" && r.PlainLyrics == "" {
+			continue
+		}
+		out = append(out, Candidate{
+			ID:         strconv.FormatInt(r.ID, 10),
+			Title:      r.TrackName,
+			Artist:     r.ArtistName,
+			Album:      r.AlbumName,
+			DurationMS: int64(r.Duration * 1000),
+			Synced:     r.SyncedLyrics != "",
+		})
+	}
+	return out, nil
+}
+
+func (p *lrclib) Fetch(ctx context.Context, id string) (string, error) {
+	var rec lrclibRecord
+	if err := p.getJSON(ctx, "/api/get/"+url.PathEscape(id), &rec); err != nil {
+		return "", err
+	}
+	if rec.SyncedLyrics != "" {
+		return rec.SyncedLyrics, nil
+	}
+	if rec.PlainLyrics != "" {
+		return rec.PlainLyrics, nil
+	}
+	return "", fmt.Errorf("lrclib: lyric %s is empty", id)
+}
+
+func (p *lrclib) getJSON(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "half-beat-player (https://github.com/Sheyiyuan/Half-Beat-Player)")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("lrclib request error: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("lrclib http %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("lrclib decode error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package lyricprovider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// NeteaseName is the registry key of the NetEase Cloud Music provider.
+const NeteaseName = "netease"
+
+const neteaseDefaultBaseURL = "https://music.163.com"
+
+var lrcTimestampRe = regexp.MustCompile(`\[\d{1,3}:\d{2}(?:[.:]\d{1,3})?\]`)
+
+const (
+	// neteaseSyncCheckLimit is how many top search hits get their lyric
+	// fetched to tell whether it is synced.
+	neteaseSyncCheckLimit = 3
+	// neteaseLyricCacheSize bounds the fetched lyric cache.
+	neteaseLyricCacheSize = 64
+)
+
+// neteaseLyrics caches lyric text fetched while searching, so picking a
+// candidate afterwards does not request it again. Providers are rebuilt per
+// call, hence the package-level cache keyed by base URL and song id.
+var neteaseLyrics = struct {
+	sync.Mutex
+	text map[string]string
+}{text: map[string]string{}}
+
+// netease implements Provider using NetEase Cloud Music's public web API.
+type netease struct {
+	client  *http.Client
+	baseURL string
+}
+
+// NewNetease builds a NetEase Cloud Music provider.
+func NewNetease(client *http.Client, cfg Config) Provider {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	base := strings.TrimRight(cfg.BaseURL, "/")
+	if base == "" {
+		base = neteaseDefaultBaseURL
+	}
+	return &netease{client: client, baseURL: base}
+}
+
+func (p *netease) Name() string { return NeteaseName }
+
+func (p *netease) Search(ctx context.Context, q Query) ([]Candidate, error) {
+	keyword := strings.TrimSpace(q.Title + " " + q.Artist)
+	params := url.Values{}
+	params.Set("s", keyword)
+	params.Set("type", "1")
+	params.Set("limit", "10")
+
+	var res struct {
+		Code   int `json:"code"`
+		Result struct {
+			Songs []struct {
+				ID       int64  `json:"id"`
+				Name     string `json:"name"`
+				Duration int64  `json:"duration"`
+				Artists  []struct {
+					Name string `json:"name"`
+				} `json:"artists"`
+				Album struct {
+					Name string `json:"name"`
+				} `json:"album"`
+			} `json:"songs"`
+		} `json:"result"`
+	}
+	if err := p.getJSON(ctx, "/api/search/get?"+params.Encode(), &res); err != nil {
+		return nil, err
+	}
+	if res.Code != 200 {
+		return nil, fmt.Errorf("netease search error: code=%d", res.Code)
+	}
+
+	out := make([]Candidate, 0, len(res.Result.Songs))
+	for _, song := range res.Result.Songs {
+		artists := make([]string, 0, len(song.Artists))
+		for _, a := range song.Artists {
+			artists = append(artists, a.Name)
+		}
+		out = append(out, Candidate{
+			ID:         strconv.FormatInt(song.ID, 10),
+			Title:      song.Name,
+			Artist:     strings.Join(artists, "/"),
+			Album:      song.Album.Name,
+			DurationMS: song.Duration,
+		})
+	}
+
+	// 搜索接口不返回歌词，只取前几个结果的歌词判断是否带时间轴；取不到的视为无时间轴
+	var wg sync.WaitGroup
+	for i := range out[:min(len(out), neteaseSyncCheckLimit)] {
+		wg.Add(1)
+		go func(c *Candidate) {
+			defer wg.Done()
+			if text, err := p.Fetch(ctx, c.ID); err == nil {
+				c.Synced = lrcTimestampRe.MatchString(text)
+			}
+		}(&out[i])
+	}
+	wg.Wait()
+	return out, nil
+}
+
+func (p *netease) Fetch(ctx context.Context, id string) (string, error) {
+	key := p.baseURL + "|" + id
+	neteaseLyrics.Lock()
+	text, ok := neteaseLyrics.text[key]
+	neteaseLyrics.Unlock()
+	if ok {
+		return text, nil
+	}
+
+	params := url.Values{}
+	params.Set("id", id)
+	params.Set("lv", "1")
+	params.Set("tv", "-1")
+
+	var res struct {
+		Code int `json:"code"`
+		Lrc  struct {
+			Lyric string `json:"lyric"`
+		} `json:"lrc"`
+	}
+	if err := p.getJSON(ctx, "/api/song/lyric?"+params.Encode(), &res); err != nil {
+		return "", err
+	}
+	if res.Code != 200 {
+		return "", fmt.Errorf("netease lyric error: code=%d", res.Code)
+	}
+	if strings.TrimSpace(res.Lrc.Lyric) == "" {
+		return "", fmt.Errorf("netease: lyric %s is empty", id)
+	}
+	neteaseLyrics.Lock()
+	if len(neteaseLyrics.text) >= neteaseLyricCacheSize {
+		clear(neteaseLyrics.text)
+	}
+	neteaseLyrics.text[key] = res.Lrc.Lyric
+	neteaseLyrics.Unlock()
+	return res.Lrc.Lyric, nil
+}
+
+func (p *netease) getJSON(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Referer", "https://music.163.com/")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("netease request error: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("netease http %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("netease decode error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+// Package lyricprovider defines pluggable online lyric sources and the
+// scoring used to rank their search results against a library song.
+package lyricprovider
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+
+	"half-beat-player/internal/textmatch"
+)
+
+// Query describes the song to look up.
+type Query struct {
+	Title      string `json:"title"`
+	Artist     string `json:"artist"`
+	DurationMS int64  `json:"durationMs"` // 0 表示未知
+}
+
+// Candidate is a search hit returned by a provider.
+type Candidate struct {
+	Provider   string  `json:"provider"`
+	ID         string  `json:"id"`
+	Title      string  `json:"title"`
+	Artist     string  `json:"artist"`
+	Album      string  `json:"album"`
+	DurationMS int64   `json:"durationMs"`
+	Synced     bool    `json:"synced"` // 是否有逐行时间轴
+	Score      float64 `json:"score"`  // 0-100，由 Rank 计算
+}
+
+// Provider is an online lyric source.
+type Provider interface {
+	// Name returns the unique provider key, e.g. "lrclib".
+	Name() string
+	// Search returns candidates for the query; scores are filled in by Rank.
+	Search(ctx context.Context, q Query) ([]Candidate, error)
+	// Fetch returns LRC text for a candidate id.
+	Fetch(ctx context.Context, id string) (string, error)
+}
+
+// Config configures a provider instance; BaseURL overrides the default endpoint.
+type Config struct {
+	Enabled bool   `json:"enabled"`
+	BaseURL string `json:"baseUrl"`
+}
+
+// Factory builds a provider from its config.
+type Factory func(client *http.Client, cfg Config) Provider
+
+// Registry holds the known provider factories.
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[string]Factory
+	defaults  map[string]Config
+	order     []string
+}
+
+// NewRegistry returns a registry preloaded with the built-in providers.
+func NewRegistry() *Registry {
+	r := &Registry{factories: map[string]Factory{}, defaults: map[string]Config{}}
+	r.Register(LRCLibName, Config{Enabled: true, BaseURL: lrclibDefaultBaseURL}, NewLRCLib)
+	r.Register(NeteaseName, Config{Enabled: true, BaseURL: neteaseDefaultBaseURL}, NewNetease)
+	return r
+}
+
+// Register adds (or replaces) a provider factory with its default config.
+func (r *Registry) Register(name string, def Config, f Factory) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.factories[name]; !ok {
+		r.order = append(r.order, name)
+	}
+	r.factories[name] = f
+	r.defaults[name] = def
+}
+
+// Names returns registered provider names in registration order.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string(nil), r.order...)
+}
+
+// resolve returns the factory and effective config of a provider.
+func (r *Registry) resolve(name string, override *Config) (Factory, Config, bool) {
+	r.mu.RLock()
+	f, ok := r.factories[name]
+	cfg := r.defaults[name]
+	r.mu.RUnlock()
+	if override != nil {
+		cfg.Enabled = override.Enabled
+		if override.BaseURL != "" {
+			cfg.BaseURL = override.BaseURL
+		}
+	}
+	return f, cfg, ok
+}
+
+// Config returns the effective config of a provider after applying override.
+func (r *Registry) Config(name string, override *Config) (Config, bool) {
+	_, cfg, ok := r.resolve(name, override)
+	return cfg, ok
+}
+
+// Build instantiates one provider; override replaces its default config.
+func (r *Registry) Build(name string, client *http.Client, override *Config) (Provider, error) {
+	f, cfg, ok := r.resolve(name, override)
+	if !ok {
+		return nil, fmt.Errorf("unknown lyric provider: %s", name)
+	}
+	return f(client, cfg), nil
+}
+
+// Enabled instantiates every provider that is enabled after applying overrides.
+func (r *Registry) Enabled(client *http.Client, overrides map[string]Config) []Provider {
+	var out []Provider
+	for _, name := range r.Names() {
+		var override *Config
+		if o, ok := overrides[name]; ok {
+			override = &o
+		}
+		f, cfg, ok := r.resolve(name, override)
+		if !ok || !cfg.Enabled {
+			continue
+		}
+		out = append(out, f(client, cfg))
+	}
+	return out
+}
+
+// SearchAll queries providers concurrently and returns ranked candidates.
+// Provider errors are collected per provider name and do not abort the search.
+func SearchAll(ctx context.Context, providers []Provider, q Query) ([]Candidate, map[string]string) {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		all  []Candidate
+		errs = map[string]string{}
+	)
+	for _, p := range providers {
+		wg.Add(1)
+		go func(p Provider) {
+			defer wg.Done()
+			res, err := p.Search(ctx, q)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[p.Name()] = err.Error()
+				return
+			}
+			for i := range res {
+				res[i].Provider = p.Name()
+			}
+			all = append(all, res...)
+		}(p)
+	}
+	wg.Wait()
+	return Rank(q, all), errs
+}
+
+// Rank scores candidates against the query and sorts them best first.
+func Rank(q Query, cands []Candidate) []Candidate {
+	for i := range cands {
+		cands[i].Score = Score(q, cands[i])
+	}
+	sort.SliceStable(cands, func(i, j int) bool { return cands[i].Score > cands[j].Score })
+	return cands
+}
+
+// Score rates a candidate from 0 to 100 using title, artist and duration.
+// Missing artist or duration information is left out of the weighting.
+func Score(q Query, c Candidate) float64 {
+	title := max(
+		textmatch.Similarity(q.Title, c.Title),
+		textmatch.Similarity(textmatch.CleanTitle(q.Title), c.Title),
+	)
+	score, weight := 0.6*title, 0.6
+
+	if q.Artist != "" && c.Artist != "" {
+		score += 0.25 * textmatch.Similarity(q.Artist, c.Artist)
+		weight += 0.25
+	}
+	if q.DurationMS > 0 && c.DurationMS > 0 {
+		diff := math.Abs(float64(q.DurationMS-c.DurationMS)) / 1000
+		// 2 秒内满分，15 秒以上为 0
+		d := 1 - math.Max(0, diff-2)/13
+		score += 0.15 * math.Max(0, d)
+		weight += 0.15
+	}
+	if c.Synced {
+		score += 0.02
+	}
+	return math.Round(math.Min(1, score/weight)*1000) / 10
+}
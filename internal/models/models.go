@@ -34,23 +34,79 @@ type Song struct {
 	PageTitle          string    `json:"pageTitle"`    // 分P标题
 	VideoTitle         string    `json:"videoTitle"`   // 视频主标题
 	TotalPages         int       `json:"totalPages"`   // 总分P数
+	Duration           int64     `json:"duration"`     // 分P时长（秒），0 表示未知
 	CreatedAt          time.Time `json:"createdAt"`
 	UpdatedAt          time.Time `json:"updatedAt"`
 }
 
+// Favorite kinds.
+const (
+	FavoriteKindNormal = ""      // 普通歌单，内容存放在 song_refs
+	FavoriteKindSmart  = "smart" // 智能歌单，内容由 Rules 计算
+)
+
 // Favorite stores a playlist of songs by id to keep schema simple.
 type Favorite struct {
+	ID        string      `gorm:"primaryKey" json:"id"`
+	Title     string      `json:"title"`
+	Kind      string      `gorm:"default:''" json:"kind"`
+	Rules     *SmartRules `gorm:"serializer:json" json:"rules,omitempty"` // 仅智能歌单
+	FolderID  string      `gorm:"index" json:"folderId"`                   // 空表示根目录
+	Position  int64       `json:"position"`                                // 在文件夹内的顺序
+	SongIDs   []SongRef   `gorm:"foreignKey:FavoriteID" json:"songIds"`
+	CreatedAt time.Time   `json:"createdAt"`
+	UpdatedAt time.Time   `json:"updatedAt"`
+}
+
+// FavoriteFolder groups favorites in the sidebar. Folders can nest.
+type FavoriteFolder struct {
 	ID        string    `gorm:"primaryKey" json:"id"`
-	Title     string    `json:"title"`
-	SongIDs   []SongRef `gorm:"foreignKey:FavoriteID" json:"songIds"`
+	Name      string    `json:"name"`
+	ParentID  string    `gorm:"index" json:"parentId"` // 空表示根目录
+	Position  int64     `json:"position"`
+	Collapsed bool      `json:"collapsed"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// FavoriteTag is a free-form tag on a favorite.
+type FavoriteTag struct {
+	FavoriteID string `gorm:"primaryKey" json:"favoriteId"`
+	Tag        string `gorm:"primaryKey;index" json:"tag"`
+}
+
+// SongTag is a free-form tag on a song.
+type SongTag struct {
+	SongID string `gorm:"primaryKey" json:"songId"`
+	Tag    string `gorm:"primaryKey;index" json:"tag"`
+}
+
+// SmartCondition is a single smart playlist rule, e.g. singer contains "X".
+type SmartCondition struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+// SmartRules combines conditions and nested groups with AND ("all") or OR ("any").
+// SortBy, Desc and Limit only apply at the top level.
+type SmartRules struct {
+	Match      string           `json:"match"`
+	Conditions []SmartCondition `json:"conditions"`
+	Groups     []SmartRules     `json:"groups,omitempty"`
+	SortBy     string           `json:"sortBy,omitempty"`
+	Desc       bool             `json:"desc,omitempty"`
+	Limit      int              `json:"limit,omitempty"`
+}
+
+// SongRef places a song in a favorite. Position is sparse (gaps between
+// neighbours) so a move only rewrites the moved row.
 type SongRef struct {
-	ID         uint   `gorm:"primaryKey" json:"id"`
-	FavoriteID string `json:"favoriteId"`
-	SongID     string `json:"songId"`
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	FavoriteID string    `gorm:"index:idx_song_ref_order,priority:1" json:"favoriteId"`
+	SongID     string    `json:"songId"`
+	Position   int64     `gorm:"index:idx_song_ref_order,priority:2" json:"position"`
+	AddedAt    time.Time `json:"addedAt"`
 }
 
 // Theme represents a theme configuration
@@ -84,6 +140,7 @@ type Playlist struct {
 type LoginSession struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	Sessdata  string    `json:"sessdata"`
+	BiliJct   string    `json:"biliJct"` // CSRF token，写操作需要
 	SavedAt   time.Time `json:"savedAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
@@ -99,28 +156,113 @@ type PlayHistory struct {
 }
 
 // LyricMapping caches text and offset.
+// It holds the original lyric of a song; additional tracks live in LyricTrack.
 type LyricMapping struct {
 	ID        string    `gorm:"primaryKey" json:"id"`
 	Lyric     string    `json:"lyric"`
 	OffsetMS  int       `json:"offsetMs"`
+	Language  string    `json:"language"` // 原文语言，如 ja, ko, zh
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// LyricTrack stores an additional lyric track (translation, romanisation) of a song.
+// OffsetMS is relative to the original lyric.
+type LyricTrack struct {
+	ID        string    `gorm:"primaryKey" json:"id"`
+	SongID    string    `gorm:"uniqueIndex:idx_lyric_track_kind" json:"songId"`
+	Kind      string    `gorm:"uniqueIndex:idx_lyric_track_kind" json:"kind"` // translation / romanization
+	Language  string    `gorm:"uniqueIndex:idx_lyric_track_kind" json:"language"`
+	Lyric     string    `json:"lyric"`
+	OffsetMS  int       `json:"offsetMs"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// AudioLoudness stores loudness measurements reported by Bilibili playurl for one page.
+// ID is "<bvid>-P<page>" so that all song instances of the same page share it.
+type AudioLoudness struct {
+	ID              string    `gorm:"primaryKey" json:"id"`
+	BVID            string    `gorm:"column:bvid;index" json:"bvid"`
+	Page            int       `json:"page"`
+	Cid             int64     `json:"cid"`
+	MeasuredI       float64   `json:"measuredI"`       // 实测综合响度 (LUFS)
+	MeasuredLRA     float64   `json:"measuredLra"`     // 响度范围 (LU)
+	MeasuredTP      float64   `json:"measuredTp"`      // 真峰值 (dBTP)
+	MeasuredThresh  float64   `json:"measuredThresh"`  // 门限 (LUFS)
+	TargetI         float64   `json:"targetI"`         // 目标响度 (LUFS)
+	TargetOffset    float64   `json:"targetOffset"`    // B 站建议的增益偏移 (dB)
+	SuggestedGainDb float64   `json:"suggestedGainDb"` // 计算后的建议增益 (dB)
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// Bilibili favorite sync policies.
+const (
+	BiliSyncMirror = "mirror" // 本地与远端保持一致
+	BiliSyncMerge  = "merge"  // 合并远端的增删，保留本地新增
+	BiliSyncPush   = "push"   // 以本地为准写回远端
+)
+
+// Bilibili collection kinds.
+const (
+	BiliCollectionFolder = "folder" // 收藏夹，ID 为 media_id
+	BiliCollectionSeason = "season" // 视频合集，ID 为 season_id，只读
+)
+
+// BiliFavoriteLink links a local favorite to a Bilibili favorite folder (or
+// collected season) and records the last sync. RemoteBVIDs is the remote
+// content after the last sync; it tells remote removals apart from local
+// additions.
+type BiliFavoriteLink struct {
+	FavoriteID  string     `gorm:"primaryKey" json:"favoriteId"`
+	RemoteKind  string     `gorm:"default:folder" json:"remoteKind"`
+	MediaID     int64      `gorm:"index" json:"mediaId"` // 合集时为 season_id
+	RemoteTitle string     `json:"remoteTitle"`
+	Policy      string     `json:"policy"` // 默认同步策略
+	RemoteBVIDs []string   `gorm:"serializer:json" json:"remoteBvids"`
+	LastSyncAt  *time.Time `json:"lastSyncAt"`
+	LastAdded   int        `json:"lastAdded"`   // 上次同步本地新增的歌曲数
+	LastRemoved int        `json:"lastRemoved"` // 上次同步本地移除的歌曲数
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
 // BiliFavoriteCollection represents a Bilibili favorite folder
 type BiliFavoriteCollection struct {
-	ID    int64  `json:"id"`
-	Title string `json:"title"`
-	Count int    `json:"count"`
-	Cover string `json:"cover"`
+	ID       int64  `json:"id"`
+	Kind     string `json:"kind,omitempty"` // folder 或 season，空表示 folder
+	Title    string `json:"title"`
+	Count    int    `json:"count"`
+	Cover    string `json:"cover"`
+	Upper    string `json:"upper,omitempty"`
+	UpperMID int64  `json:"upperMid,omitempty"`
+	HasVideo bool   `json:"hasVideo"` // 指定视频是否已在此收藏夹中，仅按视频查询时有效
 }
 
 // BiliFavoriteInfo represents a single favorite item (video)
 type BiliFavoriteInfo struct {
+	AID   int64  `json:"aid,omitempty"`
 	BVID  string `json:"bvid"`
 	Title string `json:"title"`
 	Cover string `json:"cover"`
 }
 
+// BiliFavoriteItem is an entry of a Bilibili favorite folder with its
+// metadata, as listed by fav/resource/list.
+type BiliFavoriteItem struct {
+	AID       int64  `json:"aid"`
+	BVID      string `json:"bvid"`
+	Type      int    `json:"type"` // 2 视频，12 音频，21 合集
+	Title     string `json:"title"`
+	Cover     string `json:"cover"`
+	Upper     string `json:"upper"`
+	UpperMID  int64  `json:"upperMid"`
+	Duration  int64  `json:"duration"` // 秒
+	Pages     int    `json:"pages"`
+	FavTime   int64  `json:"favTime"` // 收藏时间，Unix 秒
+	Available bool   `json:"available"`
+	Reason    string `json:"reason,omitempty"` // 不可用原因
+}
+
 // BiliAudio captures resolved audio URL and cache metadata
 type BiliAudio struct {
 	URL       string    `json:"url"`
@@ -141,6 +283,13 @@ type PageInfo struct {
 	Duration int64  `json:"duration"`
 }
 
+// VideoChapter represents a chapter marker (view_point) of a Bilibili video page.
+type VideoChapter struct {
+	From    int64  `json:"from"`    // 起始秒
+	To      int64  `json:"to"`      // 结束秒
+	Content string `json:"content"` // 章节标题
+}
+
 // CompleteVideoInfo represents complete information about a Bilibili video
 type CompleteVideoInfo struct {
 	BVID     string     `json:"bvid"`
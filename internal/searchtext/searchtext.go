@@ -0,0 +1,161 @@
+// Package searchtext folds text for the local search index: traditional
+// Chinese is mapped to simplified, full-width forms to ASCII and letters to
+// lower case, and Han characters can be spelled out as pinyin.
+//
+// Folding works rune by rune so that a match found in folded text can be
+// mapped back to the same rune offsets in the original text.
+package searchtext
+
+import (
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/longbridgeapp/opencc"
+	"github.com/mozillazg/go-pinyin"
+)
+
+var (
+	t2sOnce sync.Once
+	t2s     *opencc.OpenCC
+	t2sErr  error
+
+	// 单字繁简映射缓存，rune -> rune
+	t2sCache sync.Map
+
+	pinyinArgs = pinyin.NewArgs()
+)
+
+func converter() *opencc.OpenCC {
+	t2sOnce.Do(func() {
+		t2s, t2sErr = opencc.New("t2s")
+	})
+	if t2sErr != nil {
+		return nil
+	}
+	return t2s
+}
+
+// toSimplified maps a single traditional Han character to its simplified
+// form. Characters without a one-rune mapping are returned unchanged.
+func toSimplified(r rune) rune {
+	if v, ok := t2sCache.Load(r); ok {
+		return v.(rune)
+	}
+	out := r
+	if cc := converter(); cc != nil {
+		if s, err := cc.Convert(string(r)); err == nil {
+			if rs := []rune(s); len(rs) == 1 {
+				out = rs[0]
+			}
+		}
+	}
+	t2sCache.Store(r, out)
+	return out
+}
+
+// FoldRune folds a single rune; see Fold.
+func FoldRune(r rune) rune {
+	switch {
+	case r == '　':
+		return ' '
+	case r >= '！' && r <= '～': // 全角 ASCII
+		r -= 0xfee0
+	case unicode.Is(unicode.Han, r):
+		return toSimplified(r)
+	}
+	return unicode.ToLower(r)
+}
+
+// Fold returns s in simplified Chinese, half-width and lower case. The result
+// has exactly as many runes as s.
+func Fold(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		b.WriteRune(FoldRune(r))
+	}
+	return b.String()
+}
+
+// Pinyin spells the Han characters of s as toneless pinyin. full joins the
+// syllables of each run of Han characters ("zhoujielun"), initials keeps the
+// first letter of each syllable ("zjl"). Letters and digits are kept as they
+// are; any other character separates words with a space.
+func Pinyin(s string) (full string, initials string) {
+	var fb, ib strings.Builder
+	sep := func() {
+		if fb.Len() > 0 && !strings.HasSuffix(fb.String(), " ") {
+			fb.WriteByte(' ')
+			ib.WriteByte(' ')
+		}
+	}
+	for _, r := range Fold(s) {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			py := pinyin.SinglePinyin(r, pinyinArgs)
+			if len(py) == 0 || py[0] == "" {
+				sep()
+				continue
+			}
+			fb.WriteString(py[0])
+			ib.WriteByte(py[0][0])
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			fb.WriteRune(r)
+			ib.WriteRune(r)
+		default:
+			sep()
+		}
+	}
+	return strings.TrimSpace(fb.String()), strings.TrimSpace(ib.String())
+}
+
+// Range is a half-open [Start, End) range of rune offsets.
+type Range struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// FindAll returns the rune ranges in s where any of the folded terms occur,
+// merged and in order.
+func FindAll(s string, terms []string) []Range {
+	if s == "" || len(terms) == 0 {
+		return nil
+	}
+	folded := []rune(Fold(s))
+	var ranges []Range
+	for _, term := range terms {
+		t := []rune(term)
+		if len(t) == 0 || len(t) > len(folded) {
+			continue
+		}
+		for i := 0; i+len(t) <= len(folded); i++ {
+			if string(folded[i:i+len(t)]) == term {
+				ranges = append(ranges, Range{Start: i, End: i + len(t)})
+			}
+		}
+	}
+	return mergeRanges(ranges)
+}
+
+func mergeRanges(ranges []Range) []Range {
+	if len(ranges) < 2 {
+		return ranges
+	}
+	// 插入排序即可，区间数量很少
+	for i := 1; i < len(ranges); i++ {
+		for j := i; j > 0 && ranges[j].Start < ranges[j-1].Start; j-- {
+			ranges[j], ranges[j-1] = ranges[j-1], ranges[j]
+		}
+	}
+	out := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &out[len(out)-1]
+		if r.Start <= last.End {
+			last.End = max(last.End, r.End)
+			continue
+		}
+		out = append(out, r)
+	}
+	return out
+}
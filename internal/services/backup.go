@@ -0,0 +1,423 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"half-beat-player/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Backup archive layout: manifest.json and data.json at the root, and the
+// optional files under the same relative paths as in the data directory.
+const (
+	backupFormat       = "half-beat-backup"
+	backupManifestName = "manifest.json"
+	backupDataName     = "data.json"
+)
+
+// backupSchemaVersion is the version written by CreateBackup.
+//
+//	0: ExportData 导出的 JSON，没有 manifest
+//	1: .zip，增加播放源、播放历史、播放队列、登录状态与文件，封面路径相对数据目录
+const backupSchemaVersion = 1
+
+// Directories that a backup may include.
+const (
+	BackupFilesThemeImages = themeImageDirName
+	BackupFilesCovers      = coversDir
+	BackupFilesDownloads   = downloadsDir
+)
+
+// BackupOptions chooses what CreateBackup puts into the archive besides the
+// database content.
+type BackupOptions struct {
+	ThemeImages bool `json:"themeImages"`
+	Covers      bool `json:"covers"`
+	Downloads   bool `json:"downloads"`
+	Login       bool `json:"login"` // 登录凭据，换机器时可免重新登录
+}
+
+// BackupManifest describes a backup archive.
+type BackupManifest struct {
+	Format        string         `json:"format"`
+	SchemaVersion int            `json:"schemaVersion"`
+	CreatedAt     time.Time      `json:"createdAt"`
+	Files         []string       `json:"files"` // 附带的目录
+	Login         bool           `json:"login"`
+	Counts        map[string]int `json:"counts"`
+}
+
+// BackupData is data.json of a backup: ExportData plus the state that
+// ExportData leaves out.
+type BackupData struct {
+	ExportData
+	StreamSources []models.StreamSource `json:"streamSources"`
+	PlayHistory   *models.PlayHistory   `json:"playHistory,omitempty"`
+	Playlist      *models.Playlist      `json:"playlist,omitempty"`
+	Login         *models.LoginSession  `json:"login,omitempty"`
+}
+
+// backupMigrations upgrade data.json from the version in the key to the next.
+var backupMigrations = map[int]func(data *BackupData){
+	0: migrateBackupV0,
+}
+
+// migrateBackupV0 rebases absolute cover paths from the machine that made the
+// export onto this data directory; version 1 stores them relative.
+func migrateBackupV0(data *BackupData) {
+	for i := range data.Songs {
+		// 导出可能来自另一个系统，两种分隔符都要处理
+		p := strings.ReplaceAll(data.Songs[i].CoverLocal, `\`, "/")
+		if p == "" {
+			continue
+		}
+		if idx := strings.LastIndex(p, "/"+coversDir+"/"); idx >= 0 {
+			data.Songs[i].CoverLocal = coversDir + "/" + p[idx+len(coversDir)+2:]
+		} else {
+			data.Songs[i].CoverLocal = ""
+		}
+	}
+}
+
+// CreateBackup writes a versioned .zip backup to dst.
+func (s *Service) CreateBackup(dst string, opts BackupOptions) (BackupManifest, error) {
+	if strings.TrimSpace(dst) == "" {
+		return BackupManifest{}, fmt.Errorf("备份路径不能为空")
+	}
+	export, err := s.ExportData()
+	if err != nil {
+		return BackupManifest{}, fmt.Errorf("导出数据失败: %w", err)
+	}
+	data := BackupData{ExportData: export, StreamSources: []models.StreamSource{}}
+	if err := s.db.Find(&data.StreamSources).Error; err != nil {
+		return BackupManifest{}, err
+	}
+	var history models.PlayHistory
+	if err := s.db.Limit(1).Find(&history).Error; err == nil && history.ID != 0 {
+		data.PlayHistory = &history
+	}
+	var queue models.Playlist
+	if err := s.db.Limit(1).Find(&queue).Error; err == nil && queue.ID != 0 {
+		data.Playlist = &queue
+	}
+	if opts.Login {
+		var session models.LoginSession
+		if err := s.db.Limit(1).Find(&session).Error; err == nil && session.Sessdata != "" {
+			data.Login = &session
+		}
+	}
+	for i := range data.Songs {
+		data.Songs[i].CoverLocal = s.relativeDataPath(data.Songs[i].CoverLocal)
+	}
+
+	manifest := BackupManifest{
+		Format:        backupFormat,
+		SchemaVersion: backupSchemaVersion,
+		CreatedAt:     time.Now(),
+		Files:         []string{},
+		Login:         data.Login != nil,
+		Counts:        backupCounts(data),
+	}
+	for _, dir := range []struct {
+		name string
+		on   bool
+	}{{BackupFilesThemeImages, opts.ThemeImages}, {BackupFilesCovers, opts.Covers}, {BackupFilesDownloads, opts.Downloads}} {
+		if dir.on {
+			manifest.Files = append(manifest.Files, dir.name)
+		}
+	}
+
+	tmp := dst + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return manifest, fmt.Errorf("创建备份文件失败: %w", err)
+	}
+	err = s.writeBackupZip(f, manifest, data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return manifest, fmt.Errorf("写入备份失败: %w", err)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return manifest, fmt.Errorf("写入备份失败: %w", err)
+	}
+	return manifest, nil
+}
+
+func backupCounts(data BackupData) map[string]int {
+	return map[string]int{
+		"songs":         len(data.Songs),
+		"favorites":     len(data.Favorites),
+		"lyrics":        len(data.Lyrics),
+		"streamSources": len(data.StreamSources),
+	}
+}
+
+func (s *Service) writeBackupZip(w io.Writer, manifest BackupManifest, data BackupData) error {
+	zw := zip.NewWriter(w)
+	for _, entry := range []struct {
+		name  string
+		value any
+	}{{backupManifestName, manifest}, {backupDataName, data}} {
+		fw, err := zw.Create(entry.name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entry.value); err != nil {
+			return err
+		}
+	}
+	for _, dir := range manifest.Files {
+		if err := addBackupDir(zw, s.dataDir, dir); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// addBackupDir stores the regular files of dataDir/dir; missing directories
+// are skipped and unfinished downloads (.part) left out.
+func addBackupDir(zw *zip.Writer, dataDir, dir string) error {
+	root := filepath.Join(dataDir, dir)
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() || strings.HasSuffix(p, ".part") {
+			return nil
+		}
+		rel, err := filepath.Rel(dataDir, p)
+		if err != nil {
+			return err
+		}
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: filepath.ToSlash(rel), Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(fw, src)
+		return err
+	})
+	return err
+}
+
+// relativeDataPath makes a path inside the data directory relative to it.
+func (s *Service) relativeDataPath(p string) string {
+	if p == "" {
+		return ""
+	}
+	rel, err := filepath.Rel(s.dataDir, p)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return p
+	}
+	return filepath.ToSlash(rel)
+}
+
+// readBackup reads the manifest and data of a .zip backup, or of a plain
+// ExportData JSON file (version 0), and migrates the data to the current
+// version.
+func (s *Service) readBackup(src string) (*zip.ReadCloser, BackupManifest, BackupData, error) {
+	var manifest BackupManifest
+	var data BackupData
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		// 不是 zip，按旧的 JSON 导出处理
+		raw, readErr := os.ReadFile(src)
+		if readErr != nil {
+			return nil, manifest, data, fmt.Errorf("读取备份失败: %w", readErr)
+		}
+		if err := json.Unmarshal(raw, &data.ExportData); err != nil {
+			return nil, manifest, data, fmt.Errorf("无法识别的备份文件: %w", err)
+		}
+		manifest = BackupManifest{Format: backupFormat, SchemaVersion: 0, Files: []string{}}
+	} else {
+		if err := readBackupJSON(zr, backupManifestName, &manifest); err != nil {
+			zr.Close()
+			return nil, manifest, data, err
+		}
+		if manifest.Format != backupFormat {
+			zr.Close()
+			return nil, manifest, data, fmt.Errorf("不是 half-beat 备份文件")
+		}
+		if manifest.SchemaVersion > backupSchemaVersion {
+			zr.Close()
+			return nil, manifest, data, fmt.Errorf("备份版本 %d 高于当前支持的版本 %d，请先升级应用", manifest.SchemaVersion, backupSchemaVersion)
+		}
+		if err := readBackupJSON(zr, backupDataName, &data); err != nil {
+			zr.Close()
+			return nil, manifest, data, err
+		}
+	}
+	for v := manifest.SchemaVersion; v < backupSchemaVersion; v++ {
+		if migrate := backupMigrations[v]; migrate != nil {
+			migrate(&data)
+		}
+	}
+	if manifest.Counts == nil {
+		manifest.Counts = backupCounts(data)
+	}
+	return zr, manifest, data, nil
+}
+
+func readBackupJSON(zr *zip.ReadCloser, name string, out any) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return fmt.Errorf("备份中缺少 %s", name)
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(out); err != nil {
+		return fmt.Errorf("解析 %s 失败: %w", name, err)
+	}
+	return nil
+}
+
+// ReadBackupManifest returns the manifest of a backup for preview. Plain JSON
+// exports report schema version 0.
+func (s *Service) ReadBackupManifest(src string) (BackupManifest, error) {
+	zr, manifest, _, err := s.readBackup(src)
+	if zr != nil {
+		zr.Close()
+	}
+	return manifest, err
+}
+
+// RestoreBackup replaces the library with a backup made by CreateBackup or
+// ExportData, migrating older versions, and puts the included files back
+// into the data directory.
+func (s *Service) RestoreBackup(src string) (BackupManifest, error) {
+	zr, manifest, data, err := s.readBackup(src)
+	if err != nil {
+		return manifest, err
+	}
+	if zr != nil {
+		defer zr.Close()
+	}
+
+	for i := range data.Songs {
+		if p := data.Songs[i].CoverLocal; p != "" && !filepath.IsAbs(p) {
+			data.Songs[i].CoverLocal = filepath.Join(s.dataDir, filepath.FromSlash(p))
+		}
+	}
+	if err := s.snapshotBefore(SnapshotBeforeRestore); err != nil {
+		return manifest, err
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := importDataTx(tx, data.ExportData); err != nil {
+			return err
+		}
+		if manifest.SchemaVersion == 0 {
+			// 旧导出没有这些数据，保留本地
+			return nil
+		}
+		if err := tx.Exec("DELETE FROM stream_sources").Error; err != nil {
+			return err
+		}
+		if len(data.StreamSources) > 0 {
+			if err := tx.Create(&data.StreamSources).Error; err != nil {
+				return err
+			}
+		}
+		if data.PlayHistory != nil {
+			if err := tx.Save(data.PlayHistory).Error; err != nil {
+				return err
+			}
+		}
+		if data.Playlist != nil {
+			if err := tx.Save(data.Playlist).Error; err != nil {
+				return err
+			}
+		}
+		if data.Login != nil {
+			if err := tx.Save(data.Login).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return manifest, fmt.Errorf("恢复数据失败: %w", err)
+	}
+
+	if zr != nil {
+		if err := s.extractBackupFiles(zr, manifest.Files); err != nil {
+			return manifest, err
+		}
+	}
+	if data.Login != nil {
+		if err := s.restoreLogin(); err != nil {
+			return manifest, fmt.Errorf("恢复登录状态失败: %w", err)
+		}
+	}
+	return manifest, nil
+}
+
+// extractBackupFiles writes the archive entries under the listed directories
+// into the data directory, overwriting existing files.
+func (s *Service) extractBackupFiles(zr *zip.ReadCloser, dirs []string) error {
+	allowed := map[string]bool{}
+	for _, dir := range dirs {
+		switch dir {
+		case BackupFilesThemeImages, BackupFilesCovers, BackupFilesDownloads:
+			allowed[dir] = true
+		}
+	}
+	for _, f := range zr.File {
+		name := path.Clean(f.Name)
+		top, _, ok := strings.Cut(name, "/")
+		if !ok || !allowed[top] || f.FileInfo().IsDir() || strings.Contains(name, "..") {
+			continue
+		}
+		dst := filepath.Join(s.dataDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return fmt.Errorf("创建目录失败: %w", err)
+		}
+		if err := extractZipFile(f, dst); err != nil {
+			return fmt.Errorf("恢复文件 %s 失败: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func extractZipFile(f *zip.File, dst string) error {
+	in, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
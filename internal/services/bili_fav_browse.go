@@ -0,0 +1,217 @@
+package services
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"half-beat-player/internal/models"
+)
+
+// 收藏的收藏夹列表中的类型
+const (
+	biliCollectedTypeFolder = 11
+	biliCollectedTypeSeason = 21
+)
+
+var biliSpaceMidRe = regexp.MustCompile(`space\.bilibili\.com/(\d+)`)
+
+// parseBiliMid accepts a bare mid, "UID:123" or a space.bilibili.com URL.
+func parseBiliMid(input string) (int64, error) {
+	input = strings.TrimSpace(input)
+	if m := biliSpaceMidRe.FindStringSubmatch(input); m != nil {
+		input = m[1]
+	}
+	if strings.HasPrefix(strings.ToUpper(input), "UID") {
+		input = strings.TrimSpace(strings.TrimLeft(input[3:], ":： "))
+	}
+	mid, err := strconv.ParseInt(input, 10, 64)
+	if err != nil || mid <= 0 {
+		return 0, fmt.Errorf("无效的用户 ID: %s", input)
+	}
+	return mid, nil
+}
+
+// GetCollectedFavoriteCollections 列出当前登录用户收藏的他人收藏夹和合集
+func (s *Service) GetCollectedFavoriteCollections() ([]models.BiliFavoriteCollection, error) {
+	if !s.IsLoggedIn() {
+		return nil, fmt.Errorf("未登录")
+	}
+	user, err := s.GetUserInfo()
+	if err != nil {
+		return nil, fmt.Errorf("获取用户信息失败: %w", err)
+	}
+
+	out := []models.BiliFavoriteCollection{}
+	for pn := 1; ; pn++ {
+		endpoint := fmt.Sprintf("https://api.bilibili.com/x/v3/fav/folder/collected/list?up_mid=%d&pn=%d&ps=%d&platform=web",
+			user.UID, pn, biliFavoritePageSize)
+		var data struct {
+			List []struct {
+				ID         int64  `json:"id"`
+				Type       int    `json:"type"`
+				Title      string `json:"title"`
+				Cover      string `json:"cover"`
+				MediaCount int    `json:"media_count"`
+				Upper      struct {
+					Mid  int64  `json:"mid"`
+					Name string `json:"name"`
+				} `json:"upper"`
+			} `json:"list"`
+			HasMore bool `json:"has_more"`
+		}
+		if err := s.getBiliJSON(endpoint, &data); err != nil {
+			return nil, err
+		}
+		for _, it := range data.List {
+			var kind string
+			switch it.Type {
+			case biliCollectedTypeFolder:
+				kind = models.BiliCollectionFolder
+			case biliCollectedTypeSeason:
+				kind = models.BiliCollectionSeason
+			default:
+				continue
+			}
+			out = append(out, models.BiliFavoriteCollection{
+				ID:       it.ID,
+				Kind:     kind,
+				Title:    it.Title,
+				Count:    it.MediaCount,
+				Cover:    normalizeBiliPic(it.Cover),
+				Upper:    it.Upper.Name,
+				UpperMID: it.Upper.Mid,
+			})
+		}
+		if !data.HasMore || len(data.List) == 0 {
+			break
+		}
+	}
+	return out, nil
+}
+
+// GetUserFavoriteCollections 列出任意用户公开的收藏夹，参数可以是 mid 或空间链接
+func (s *Service) GetUserFavoriteCollections(midOrURL string) ([]models.BiliFavoriteCollection, error) {
+	mid, err := parseBiliMid(midOrURL)
+	if err != nil {
+		return nil, err
+	}
+	return s.listCreatedFavoriteCollections(mid, 0)
+}
+
+// biliSeasonPage is one page of x/space/fav/season/list.
+type biliSeasonPage struct {
+	Info struct {
+		ID         int64  `json:"id"`
+		Title      string `json:"title"`
+		Cover      string `json:"cover"`
+		MediaCount int    `json:"media_count"`
+		Upper      struct {
+			Mid  int64  `json:"mid"`
+			Name string `json:"name"`
+		} `json:"upper"`
+	} `json:"info"`
+	Medias []struct {
+		ID       int64  `json:"id"`
+		Title    string `json:"title"`
+		Cover    string `json:"cover"`
+		Duration int64  `json:"duration"`
+		BVID     string `json:"bvid"`
+		Upper    struct {
+			Mid  int64  `json:"mid"`
+			Name string `json:"name"`
+		} `json:"upper"`
+	} `json:"medias"`
+}
+
+func (s *Service) getBiliSeasonPage(seasonID int64, pn, ps int) (biliSeasonPage, error) {
+	endpoint := fmt.Sprintf("https://api.bilibili.com/x/space/fav/season/list?season_id=%d&pn=%d&ps=%d", seasonID, pn, ps)
+	var data biliSeasonPage
+	err := s.getBiliJSON(endpoint, &data)
+	return data, err
+}
+
+func (p biliSeasonPage) collection() models.BiliFavoriteCollection {
+	return models.BiliFavoriteCollection{
+		ID:       p.Info.ID,
+		Kind:     models.BiliCollectionSeason,
+		Title:    p.Info.Title,
+		Count:    p.Info.MediaCount,
+		Cover:    normalizeBiliPic(p.Info.Cover),
+		Upper:    p.Info.Upper.Name,
+		UpperMID: p.Info.Upper.Mid,
+	}
+}
+
+// getBiliSeasonInfo returns the title, cover and size of a video season.
+func (s *Service) getBiliSeasonInfo(seasonID int64) (models.BiliFavoriteCollection, error) {
+	page, err := s.getBiliSeasonPage(seasonID, 1, 1)
+	if err != nil {
+		return models.BiliFavoriteCollection{}, err
+	}
+	return page.collection(), nil
+}
+
+// fetchBiliSeasonContent pages through a video season. The API has no
+// has_more flag, so paging stops once media_count entries were read.
+func (s *Service) fetchBiliSeasonContent(seasonID int64) (BiliFavoriteContent, error) {
+	out := BiliFavoriteContent{Items: []models.BiliFavoriteItem{}, Skipped: []models.BiliFavoriteItem{}}
+	done := 0
+	for pn := 1; ; pn++ {
+		page, err := s.getBiliSeasonPage(seasonID, pn, biliFavoritePageSize)
+		if err != nil {
+			return out, err
+		}
+		if pn == 1 {
+			out.Info = page.collection()
+		}
+		for _, m := range page.Medias {
+			// 合集接口不返回分P数，Pages 留 0 表示未知，导入时再取视频信息
+			item := models.BiliFavoriteItem{
+				AID:       m.ID,
+				BVID:      m.BVID,
+				Type:      2,
+				Title:     m.Title,
+				Cover:     normalizeBiliPic(m.Cover),
+				Upper:     m.Upper.Name,
+				UpperMID:  m.Upper.Mid,
+				Duration:  m.Duration,
+				Available: m.BVID != "",
+			}
+			if item.Available {
+				out.Items = append(out.Items, item)
+			} else {
+				item.Reason = "缺少 BV 号"
+				out.Skipped = append(out.Skipped, item)
+			}
+		}
+		done += len(page.Medias)
+		s.emitEvent(biliFavoriteProgressEvent, BiliFavoriteProgress{
+			MediaID: seasonID, Stage: "fetch", Done: done, Total: max(out.Info.Count, done),
+		})
+		if len(page.Medias) == 0 || done >= out.Info.Count {
+			break
+		}
+	}
+	return out, nil
+}
+
+// FetchBiliSeasonItems lists every video of a Bilibili video season (合集).
+// Progress is reported through the "bilifav:progress" event.
+func (s *Service) FetchBiliSeasonItems(seasonID int64) (BiliFavoriteContent, error) {
+	if seasonID <= 0 {
+		return BiliFavoriteContent{}, fmt.Errorf("无效的合集 ID: %d", seasonID)
+	}
+	return s.fetchBiliSeasonContent(seasonID)
+}
+
+// ImportBiliSeason imports a Bilibili video season into favoriteID, or into a
+// new favorite named after the season when favoriteID is empty.
+func (s *Service) ImportBiliSeason(seasonID int64, favoriteID string) (BiliFavoriteImportResult, error) {
+	content, err := s.FetchBiliSeasonItems(seasonID)
+	if err != nil {
+		return BiliFavoriteImportResult{FavoriteID: favoriteID}, err
+	}
+	return s.importBiliContent(seasonID, content, favoriteID)
+}
@@ -0,0 +1,293 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"half-beat-player/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// biliFavoriteProgressEvent is emitted while a favorite folder is fetched or
+// imported.
+const biliFavoriteProgressEvent = "bilifav:progress"
+
+// fav/resource/list 每页最多 20 条
+const biliFavoritePageSize = 20
+
+// BiliFavoriteProgress is the payload of biliFavoriteProgressEvent.
+type BiliFavoriteProgress struct {
+	MediaID int64  `json:"mediaId"`
+	Stage   string `json:"stage"` // fetch：拉取列表，resolve：获取分P信息
+	Done    int    `json:"done"`
+	Total   int    `json:"total"`
+}
+
+// BiliFavoriteContent is the full content of a Bilibili favorite folder.
+type BiliFavoriteContent struct {
+	Info    models.BiliFavoriteCollection `json:"info"`
+	Items   []models.BiliFavoriteItem     `json:"items"`   // 可导入的视频
+	Skipped []models.BiliFavoriteItem     `json:"skipped"` // 已失效或不是视频的条目
+}
+
+// BiliFavoriteImportResult reports what ImportBiliFavorite did.
+type BiliFavoriteImportResult struct {
+	FavoriteID string                    `json:"favoriteId"`
+	Title      string                    `json:"title"`
+	Added      int                       `json:"added"` // 新加入歌单的歌曲数
+	Skipped    []models.BiliFavoriteItem `json:"skipped"`
+	Failed     []string                  `json:"failed"` // 获取分P信息失败的 BV 号
+}
+
+// getBiliJSON GETs a Bilibili API endpoint and decodes its data field into out.
+func (s *Service) getBiliJSON(endpoint string, out any) error {
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("创建请求失败: %w", err)
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36")
+	req.Header.Set("Referer", "https://www.bilibili.com/")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取响应失败: %w", err)
+	}
+	// 检测是否返回了 HTML 错误页面
+	if len(body) > 0 && body[0] == '<' {
+		return fmt.Errorf("收藏夹不存在或无权限访问")
+	}
+
+	var res struct {
+		Code int             `json:"code"`
+		Msg  string          `json:"message"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return fmt.Errorf("解析响应失败: %w, body: %s", err, string(body[:min(len(body), 200)]))
+	}
+	if res.Code != 0 {
+		msg := res.Msg
+		if msg == "" {
+			msg = "未知错误"
+		}
+		return fmt.Errorf("API 错误 (code=%d): %s", res.Code, msg)
+	}
+	if out != nil && len(res.Data) > 0 && string(res.Data) != "null" {
+		if err := json.Unmarshal(res.Data, out); err != nil {
+			return fmt.Errorf("解析响应失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// fetchBiliFavoriteContent pages through fav/resource/list.
+func (s *Service) fetchBiliFavoriteContent(mediaID int64) (BiliFavoriteContent, error) {
+	out := BiliFavoriteContent{Items: []models.BiliFavoriteItem{}, Skipped: []models.BiliFavoriteItem{}}
+	done := 0
+	for pn := 1; ; pn++ {
+		endpoint := fmt.Sprintf("https://api.bilibili.com/x/v3/fav/resource/list?media_id=%d&pn=%d&ps=%d&order=mtime&type=0&platform=web",
+			mediaID, pn, biliFavoritePageSize)
+		var data struct {
+			Info struct {
+				ID         int64  `json:"id"`
+				Title      string `json:"title"`
+				Cover      string `json:"cover"`
+				MediaCount int    `json:"media_count"`
+			} `json:"info"`
+			Medias []struct {
+				ID       int64  `json:"id"`
+				Type     int    `json:"type"`
+				Title    string `json:"title"`
+				Cover    string `json:"cover"`
+				Page     int    `json:"page"`
+				Duration int64  `json:"duration"`
+				Attr     int    `json:"attr"`
+				FavTime  int64  `json:"fav_time"`
+				BVID     string `json:"bvid"`
+				BvID     string `json:"bv_id"`
+				Upper    struct {
+					Mid  int64  `json:"mid"`
+					Name string `json:"name"`
+				} `json:"upper"`
+			} `json:"medias"`
+			HasMore bool `json:"has_more"`
+		}
+		if err := s.getBiliJSON(endpoint, &data); err != nil {
+			return out, err
+		}
+		if pn == 1 {
+			out.Info = models.BiliFavoriteCollection{
+				ID:    data.Info.ID,
+				Title: data.Info.Title,
+				Count: data.Info.MediaCount,
+				Cover: normalizeBiliPic(data.Info.Cover),
+			}
+		}
+
+		for _, m := range data.Medias {
+			bvid := m.BVID
+			if bvid == "" {
+				bvid = m.BvID
+			}
+			item := models.BiliFavoriteItem{
+				AID:       m.ID,
+				BVID:      bvid,
+				Type:      m.Type,
+				Title:     m.Title,
+				Cover:     normalizeBiliPic(m.Cover),
+				Upper:     m.Upper.Name,
+				UpperMID:  m.Upper.Mid,
+				Duration:  m.Duration,
+				Pages:     m.Page,
+				FavTime:   m.FavTime,
+				Available: true,
+			}
+			// attr 第 0 位表示失效，9 为 UP 主自己删除
+			switch {
+			case m.Attr == 9:
+				item.Available, item.Reason = false, "UP 主已删除"
+			case m.Attr&1 != 0 || m.Title == "已失效视频":
+				item.Available, item.Reason = false, "视频已失效"
+			case m.Type != 2:
+				item.Available, item.Reason = false, "不是视频"
+			case bvid == "":
+				item.Available, item.Reason = false, "缺少 BV 号"
+			}
+			if item.Available {
+				out.Items = append(out.Items, item)
+			} else {
+				out.Skipped = append(out.Skipped, item)
+			}
+		}
+		done += len(data.Medias)
+		s.emitEvent(biliFavoriteProgressEvent, BiliFavoriteProgress{
+			MediaID: mediaID, Stage: "fetch", Done: done, Total: max(out.Info.Count, done),
+		})
+		if !data.HasMore || len(data.Medias) == 0 {
+			break
+		}
+	}
+	return out, nil
+}
+
+// FetchBiliFavoriteItems lists every entry of a Bilibili favorite folder with
+// title, cover, upper, duration, page count and availability. Progress is
+// reported through the "bilifav:progress" event.
+func (s *Service) FetchBiliFavoriteItems(mediaID int64) (BiliFavoriteContent, error) {
+	if mediaID <= 0 {
+		return BiliFavoriteContent{}, fmt.Errorf("无效的收藏夹 ID: %d", mediaID)
+	}
+	return s.fetchBiliFavoriteContent(mediaID)
+}
+
+// songsForFavoriteItems returns one song per page for each item: the
+// library's song for that page, a song built from the item for single page
+// videos, or songs built from the video info for missing pages of multi page
+// videos. Items with an unknown page count (0, as for seasons) are resolved
+// like multi page videos.
+func (s *Service) songsForFavoriteItems(mediaID int64, items []models.BiliFavoriteItem) (map[string][]models.Song, []string, error) {
+	out := make(map[string][]models.Song, len(items))
+	failed := []string{}
+	bvids := make([]string, 0, len(items))
+	for _, it := range items {
+		bvids = append(bvids, it.BVID)
+	}
+	existing, err := libraryPageSongs(s.db, bvids)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	multi := []string{}
+	for _, it := range items {
+		if it.Pages != 1 {
+			multi = append(multi, it.BVID)
+			continue
+		}
+		if song, ok := existing[it.BVID][1]; ok {
+			out[it.BVID] = []models.Song{song}
+			continue
+		}
+		out[it.BVID] = []models.Song{{
+			ID:         uuid.NewString(),
+			BVID:       it.BVID,
+			Name:       it.Title,
+			Singer:     it.Upper,
+			Cover:      it.Cover,
+			PageNumber: 1,
+			VideoTitle: it.Title,
+			TotalPages: 1,
+			Duration:   it.Duration,
+		}}
+	}
+	for i, bvid := range multi {
+		if songs, ok := s.completeBiliPages(bvid, existing[bvid]); ok {
+			out[bvid] = songs
+		} else {
+			failed = append(failed, bvid)
+		}
+		s.emitEvent(biliFavoriteProgressEvent, BiliFavoriteProgress{
+			MediaID: mediaID, Stage: "resolve", Done: i + 1, Total: len(multi),
+		})
+	}
+	return out, failed, nil
+}
+
+// ImportBiliFavorite imports a whole Bilibili favorite folder into favoriteID,
+// or into a new favorite named after the folder when favoriteID is empty.
+// Songs already in the library are reused; unavailable entries are skipped
+// and listed in the result.
+func (s *Service) ImportBiliFavorite(mediaID int64, favoriteID string) (BiliFavoriteImportResult, error) {
+	content, err := s.FetchBiliFavoriteItems(mediaID)
+	if err != nil {
+		return BiliFavoriteImportResult{FavoriteID: favoriteID}, err
+	}
+	return s.importBiliContent(mediaID, content, favoriteID)
+}
+
+// importBiliContent stores fetched folder or season content in a favorite.
+func (s *Service) importBiliContent(remoteID int64, content BiliFavoriteContent, favoriteID string) (BiliFavoriteImportResult, error) {
+	result := BiliFavoriteImportResult{FavoriteID: favoriteID}
+	result.Skipped = content.Skipped
+	result.Title = content.Info.Title
+
+	// 网络请求放在事务之外
+	songsByBVID, failed, err := s.songsForFavoriteItems(remoteID, content.Items)
+	if err != nil {
+		return result, err
+	}
+	result.Failed = failed
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if favoriteID == "" {
+			title := content.Info.Title
+			if title == "" {
+				title = fmt.Sprintf("收藏夹 %d", remoteID)
+			}
+			fav := models.Favorite{ID: "FavList-" + uuid.NewString(), Title: title}
+			if err := tx.Create(&fav).Error; err != nil {
+				return err
+			}
+			result.FavoriteID = fav.ID
+		}
+
+		songs := []models.Song{}
+		for _, it := range content.Items {
+			songs = append(songs, songsByBVID[it.BVID]...)
+		}
+		var err error
+		if result.Added, err = addNewSongsToFavorite(tx, result.FavoriteID, songs); err != nil {
+			return err
+		}
+		return touchFavorite(tx, result.FavoriteID)
+	})
+	return result, err
+}
@@ -0,0 +1,588 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"half-beat-player/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// BiliFavoriteDiff compares a linked favorite with its Bilibili folder by BV
+// number. Without a previous sync every difference counts as an addition.
+type BiliFavoriteDiff struct {
+	FavoriteID    string   `json:"favoriteId"`
+	MediaID       int64    `json:"mediaId"`
+	FirstSync     bool     `json:"firstSync"`
+	RemoteAdded   []string `json:"remoteAdded"`   // 远端新增，本地没有
+	RemoteRemoved []string `json:"remoteRemoved"` // 远端已删除，本地仍有
+	LocalAdded    []string `json:"localAdded"`    // 本地新增，远端没有
+	LocalRemoved  []string `json:"localRemoved"`  // 本地已删除，远端仍有
+	Unchanged     int      `json:"unchanged"`
+
+	remote     []string
+	remoteAIDs map[string]int64
+}
+
+// BiliFavoriteSyncResult reports what SyncBiliFavorite changed.
+type BiliFavoriteSyncResult struct {
+	Diff    BiliFavoriteDiff `json:"diff"`
+	Policy  string           `json:"policy"`
+	Added   int              `json:"added"`   // 本地新增的歌曲数
+	Removed int              `json:"removed"` // 本地移除的歌曲数
+	// 写回远端的视频数，仅 push 策略
+	PushedAdded   int                     `json:"pushedAdded"`
+	PushedRemoved int                     `json:"pushedRemoved"`
+	Failed        []string                `json:"failed"` // 获取信息或写回失败的 BV 号
+	Link          models.BiliFavoriteLink `json:"link"`
+
+	// 首次写回会删除远端独有的视频，此时不做任何修改，需确认后调用 ConfirmBiliFavoritePush
+	NeedsConfirm bool `json:"needsConfirm"`
+}
+
+func validBiliSyncPolicy(policy string) bool {
+	switch policy {
+	case models.BiliSyncMirror, models.BiliSyncMerge, models.BiliSyncPush:
+		return true
+	}
+	return false
+}
+
+// getBiliFavoriteLink loads the link of a favorite.
+func getBiliFavoriteLink(tx *gorm.DB, favoriteID string) (models.BiliFavoriteLink, error) {
+	var link models.BiliFavoriteLink
+	if err := tx.First(&link, "favorite_id = ?", favoriteID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return link, fmt.Errorf("歌单未关联 B 站收藏夹")
+		}
+		return link, err
+	}
+	return link, nil
+}
+
+// LinkFavoriteToBili links a favorite to the Bilibili folder mediaID. policy
+// is the default for SyncBiliFavorite; empty means merge. Relinking to another
+// folder forgets the previous sync.
+func (s *Service) LinkFavoriteToBili(favoriteID string, mediaID int64, policy string) (models.BiliFavoriteLink, error) {
+	return s.linkFavoriteToBili(favoriteID, models.BiliCollectionFolder, mediaID, policy)
+}
+
+// LinkFavoriteToBiliSeason links a favorite to a Bilibili video season
+// (合集). Seasons are read-only, so the push policy is not allowed.
+func (s *Service) LinkFavoriteToBiliSeason(favoriteID string, seasonID int64, policy string) (models.BiliFavoriteLink, error) {
+	return s.linkFavoriteToBili(favoriteID, models.BiliCollectionSeason, seasonID, policy)
+}
+
+func (s *Service) linkFavoriteToBili(favoriteID string, kind string, remoteID int64, policy string) (models.BiliFavoriteLink, error) {
+	if remoteID <= 0 {
+		return models.BiliFavoriteLink{}, fmt.Errorf("无效的收藏夹 ID: %d", remoteID)
+	}
+	if policy == "" {
+		policy = models.BiliSyncMerge
+	}
+	if !validBiliSyncPolicy(policy) {
+		return models.BiliFavoriteLink{}, fmt.Errorf("未知的同步策略: %s", policy)
+	}
+	if kind == models.BiliCollectionSeason && policy == models.BiliSyncPush {
+		return models.BiliFavoriteLink{}, fmt.Errorf("合集不支持写回")
+	}
+	var title string
+	if kind == models.BiliCollectionSeason {
+		info, err := s.getBiliSeasonInfo(remoteID)
+		if err != nil {
+			return models.BiliFavoriteLink{}, err
+		}
+		title = info.Title
+	} else {
+		info, err := s.GetFavoriteCollectionInfo(remoteID)
+		if err != nil {
+			return models.BiliFavoriteLink{}, err
+		}
+		title = info.Title
+	}
+
+	var link models.BiliFavoriteLink
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureEditableFavorite(tx, favoriteID); err != nil {
+			return err
+		}
+		if err := tx.Where("favorite_id = ?", favoriteID).Limit(1).Find(&link).Error; err != nil {
+			return err
+		}
+		if link.MediaID != remoteID || biliLinkKind(link) != kind {
+			link = models.BiliFavoriteLink{FavoriteID: favoriteID, RemoteKind: kind, MediaID: remoteID}
+		}
+		link.RemoteTitle = title
+		link.Policy = policy
+		return tx.Save(&link).Error
+	})
+	return link, err
+}
+
+// biliLinkKind returns the remote kind of a link; links made before seasons
+// were supported have no kind and point at folders.
+func biliLinkKind(link models.BiliFavoriteLink) string {
+	if link.RemoteKind == "" {
+		return models.BiliCollectionFolder
+	}
+	return link.RemoteKind
+}
+
+// UnlinkFavoriteFromBili removes the Bilibili link of a favorite. Its songs
+// are kept.
+func (s *Service) UnlinkFavoriteFromBili(favoriteID string) error {
+	return s.db.Delete(&models.BiliFavoriteLink{}, "favorite_id = ?", favoriteID).Error
+}
+
+// GetBiliFavoriteLink returns the Bilibili link of a favorite, or nil.
+func (s *Service) GetBiliFavoriteLink(favoriteID string) (*models.BiliFavoriteLink, error) {
+	var link models.BiliFavoriteLink
+	if err := s.db.Where("favorite_id = ?", favoriteID).Limit(1).Find(&link).Error; err != nil {
+		return nil, err
+	}
+	if link.FavoriteID == "" {
+		return nil, nil
+	}
+	return &link, nil
+}
+
+// ListBiliFavoriteLinks returns every linked favorite.
+func (s *Service) ListBiliFavoriteLinks() ([]models.BiliFavoriteLink, error) {
+	links := []models.BiliFavoriteLink{}
+	if err := s.db.Order("created_at").Find(&links).Error; err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// favoriteBVIDs returns the distinct BV numbers of a favorite's songs in
+// favorite order.
+func favoriteBVIDs(tx *gorm.DB, favoriteID string) ([]string, error) {
+	var rows []string
+	if err := tx.Table("song_refs").Select("songs.bvid").
+		Joins("JOIN songs ON songs.id = song_refs.song_id").
+		Where("song_refs.favorite_id = ? AND songs.bvid <> ''", favoriteID).
+		Order("song_refs.position, song_refs.id").Pluck("songs.bvid", &rows).Error; err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(rows))
+	seen := make(map[string]bool, len(rows))
+	for _, b := range rows {
+		if !seen[b] {
+			seen[b] = true
+			out = append(out, b)
+		}
+	}
+	return out, nil
+}
+
+// classifyBiliFavorite classifies BV numbers; last is nil before the first sync.
+func classifyBiliFavorite(remote, local, last []string) BiliFavoriteDiff {
+	d := BiliFavoriteDiff{
+		FirstSync:     last == nil,
+		RemoteAdded:   []string{},
+		RemoteRemoved: []string{},
+		LocalAdded:    []string{},
+		LocalRemoved:  []string{},
+		remote:        remote,
+	}
+	toSet := func(list []string) map[string]bool {
+		m := make(map[string]bool, len(list))
+		for _, b := range list {
+			m[b] = true
+		}
+		return m
+	}
+	inRemote, inLocal, inLast := toSet(remote), toSet(local), toSet(last)
+	for _, b := range remote {
+		switch {
+		case inLocal[b]:
+			d.Unchanged++
+		case inLast[b]:
+			d.LocalRemoved = append(d.LocalRemoved, b)
+		default:
+			d.RemoteAdded = append(d.RemoteAdded, b)
+		}
+	}
+	for _, b := range local {
+		if inRemote[b] {
+			continue
+		}
+		if inLast[b] {
+			d.RemoteRemoved = append(d.RemoteRemoved, b)
+		} else {
+			d.LocalAdded = append(d.LocalAdded, b)
+		}
+	}
+	return d
+}
+
+// diffBiliFavorite fetches the remote folder and compares it with the favorite.
+func (s *Service) diffBiliFavorite(link models.BiliFavoriteLink) (BiliFavoriteDiff, error) {
+	var items []models.BiliFavoriteInfo
+	if biliLinkKind(link) == models.BiliCollectionSeason {
+		content, err := s.fetchBiliSeasonContent(link.MediaID)
+		if err != nil {
+			return BiliFavoriteDiff{}, err
+		}
+		for _, it := range content.Items {
+			items = append(items, models.BiliFavoriteInfo{AID: it.AID, BVID: it.BVID, Title: it.Title, Cover: it.Cover})
+		}
+	} else {
+		var err error
+		if items, err = s.fetchFavoriteResourceIDs(link.MediaID); err != nil {
+			return BiliFavoriteDiff{}, err
+		}
+	}
+	remote := make([]string, 0, len(items))
+	aids := make(map[string]int64, len(items))
+	for _, it := range items {
+		remote = append(remote, it.BVID)
+		aids[it.BVID] = it.AID
+	}
+	local, err := favoriteBVIDs(s.db, link.FavoriteID)
+	if err != nil {
+		return BiliFavoriteDiff{}, err
+	}
+	last := link.RemoteBVIDs
+	if link.LastSyncAt == nil {
+		last = nil
+	} else if last == nil {
+		last = []string{}
+	}
+	d := classifyBiliFavorite(remote, local, last)
+	d.FavoriteID = link.FavoriteID
+	d.MediaID = link.MediaID
+	d.remoteAIDs = aids
+	return d, nil
+}
+
+// DiffBiliFavorite compares a linked favorite with its Bilibili folder
+// without changing anything.
+func (s *Service) DiffBiliFavorite(favoriteID string) (BiliFavoriteDiff, error) {
+	link, err := getBiliFavoriteLink(s.db, favoriteID)
+	if err != nil {
+		return BiliFavoriteDiff{}, err
+	}
+	return s.diffBiliFavorite(link)
+}
+
+// isSegmentSong reports whether a song plays only part of its page. An end
+// at the page length counts as the whole page.
+func isSegmentSong(song models.Song) bool {
+	if song.SkipStartTime > 0 {
+		return true
+	}
+	return song.SkipEndTime > 0 && (song.Duration == 0 || song.SkipEndTime < float64(song.Duration)-1)
+}
+
+// segmentEnd returns the song's end time, or 0 when it plays to the page end.
+func segmentEnd(song models.Song) float64 {
+	if song.Duration > 0 && song.SkipEndTime >= float64(song.Duration)-1 {
+		return 0
+	}
+	return song.SkipEndTime
+}
+
+// libraryPageSongs picks one library song per (bvid, page): the oldest song
+// that plays the whole page, or the oldest segment when the page only exists
+// split up. Duplicate copies are never returned.
+func libraryPageSongs(db *gorm.DB, bvids []string) (map[string]map[int]models.Song, error) {
+	out := make(map[string]map[int]models.Song, len(bvids))
+	if len(bvids) == 0 {
+		return out, nil
+	}
+	var songs []models.Song
+	if err := db.Where("bvid IN ?", bvids).Order("created_at, id").Find(&songs).Error; err != nil {
+		return nil, err
+	}
+	for _, song := range songs {
+		pages := out[song.BVID]
+		if pages == nil {
+			pages = map[int]models.Song{}
+			out[song.BVID] = pages
+		}
+		page := max(song.PageNumber, 1)
+		if cur, ok := pages[page]; !ok || (isSegmentSong(cur) && !isSegmentSong(song)) {
+			pages[page] = song
+		}
+	}
+	return out, nil
+}
+
+// completeBiliPages returns one song per page of bvid, reusing the songs in
+// have and building new ones for the pages the library lacks. The video info
+// is only fetched when have does not already cover every page. ok is false
+// when the info is unavailable and there is nothing to fall back on.
+func (s *Service) completeBiliPages(bvid string, have map[int]models.Song) ([]models.Song, bool) {
+	complete := len(have) > 0
+	for page, song := range have {
+		if song.TotalPages != len(have) || page > len(have) {
+			complete = false
+			break
+		}
+	}
+	if !complete {
+		info, err := s.getCompleteVideoInfo(bvid)
+		if err != nil {
+			if len(have) == 0 {
+				return nil, false
+			}
+		} else {
+			songs := make([]models.Song, 0, len(info.Pages))
+			for _, page := range info.Pages {
+				if song, ok := have[page.Page]; ok {
+					songs = append(songs, song)
+				} else if song, ok := newPageSong(info, page.Page); ok {
+					songs = append(songs, song)
+				}
+			}
+			return songs, true
+		}
+	}
+	pages := make([]int, 0, len(have))
+	for page := range have {
+		pages = append(pages, page)
+	}
+	slices.Sort(pages)
+	songs := make([]models.Song, 0, len(pages))
+	for _, page := range pages {
+		songs = append(songs, have[page])
+	}
+	return songs, true
+}
+
+// songsForBVIDs returns the songs to add for each BV number, one per page:
+// the library's song for that page, or a new song built from the video info.
+// BV numbers whose info cannot be fetched and that have no songs in the
+// library are returned as failed.
+func (s *Service) songsForBVIDs(bvids []string) (map[string][]models.Song, []string, error) {
+	out := make(map[string][]models.Song, len(bvids))
+	failed := []string{}
+	existing, err := libraryPageSongs(s.db, bvids)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, bvid := range bvids {
+		songs, ok := s.completeBiliPages(bvid, existing[bvid])
+		if !ok {
+			failed = append(failed, bvid)
+			continue
+		}
+		out[bvid] = songs
+	}
+	return out, failed, nil
+}
+
+// addNewSongsToFavorite creates the songs not yet in the library and adds
+// all of them to a favorite, skipping songs it already has. It returns the
+// number of refs added.
+func addNewSongsToFavorite(tx *gorm.DB, favoriteID string, songs []models.Song) (int, error) {
+	ids := make([]string, 0, len(songs))
+	for _, song := range songs {
+		var count int64
+		if err := tx.Model(&models.Song{}).Where("id = ?", song.ID).Count(&count).Error; err != nil {
+			return 0, err
+		}
+		if count == 0 {
+			if err := tx.Create(&song).Error; err != nil {
+				return 0, err
+			}
+		}
+		ids = append(ids, song.ID)
+	}
+	before, err := orderedSongRefs(tx, favoriteID)
+	if err != nil {
+		return 0, err
+	}
+	if err := addSongsToFavorite(tx, favoriteID, ids, FavoriteAddOptions{}); err != nil {
+		return 0, err
+	}
+	after, err := orderedSongRefs(tx, favoriteID)
+	if err != nil {
+		return 0, err
+	}
+	return len(after) - len(before), nil
+}
+
+// SyncBiliFavorite applies the difference between a linked favorite and its
+// Bilibili folder. policy overrides the link's default:
+//   - mirror: the favorite becomes a copy of the remote folder;
+//   - merge: remote additions and removals are applied, local additions and
+//     removals are kept;
+//   - push: the remote folder becomes a copy of the favorite.
+func (s *Service) SyncBiliFavorite(favoriteID string, policy string) (BiliFavoriteSyncResult, error) {
+	var result BiliFavoriteSyncResult
+	link, err := getBiliFavoriteLink(s.db, favoriteID)
+	if err != nil {
+		return result, err
+	}
+	if policy == "" {
+		policy = link.Policy
+	}
+	if policy == "" {
+		policy = models.BiliSyncMerge
+	}
+	if !validBiliSyncPolicy(policy) {
+		return result, fmt.Errorf("未知的同步策略: %s", policy)
+	}
+	diff, err := s.diffBiliFavorite(link)
+	if err != nil {
+		return result, err
+	}
+	result.Diff = diff
+	result.Policy = policy
+	if policy == models.BiliSyncPush {
+		if biliLinkKind(link) == models.BiliCollectionSeason {
+			return result, fmt.Errorf("合集不支持写回")
+		}
+		if diff.FirstSync && len(diff.RemoteAdded) > 0 {
+			result.NeedsConfirm = true
+			return result, nil
+		}
+		return s.pushBiliFavorite(link, result, nil)
+	}
+
+	add := diff.RemoteAdded
+	remove := diff.RemoteRemoved
+	if policy == models.BiliSyncMirror {
+		add = append(append([]string{}, diff.RemoteAdded...), diff.LocalRemoved...)
+		remove = append(append([]string{}, diff.RemoteRemoved...), diff.LocalAdded...)
+	}
+	// 网络请求放在事务之外
+	songsByBVID, failed, err := s.songsForBVIDs(add)
+	if err != nil {
+		return result, err
+	}
+	result.Failed = failed
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureEditableFavorite(tx, favoriteID); err != nil {
+			return err
+		}
+		if len(remove) > 0 {
+			sub := tx.Model(&models.Song{}).Select("id").Where("bvid IN ?", remove)
+			res := tx.Where("favorite_id = ? AND song_id IN (?)", favoriteID, sub).Delete(&models.SongRef{})
+			if res.Error != nil {
+				return res.Error
+			}
+			result.Removed = int(res.RowsAffected)
+		}
+
+		songs := []models.Song{}
+		for _, bvid := range add {
+			songs = append(songs, songsByBVID[bvid]...)
+		}
+		if result.Added, err = addNewSongsToFavorite(tx, favoriteID, songs); err != nil {
+			return err
+		}
+		if result.Added > 0 || result.Removed > 0 {
+			if err := touchFavorite(tx, favoriteID); err != nil {
+				return err
+			}
+		}
+
+		// 获取失败的 BV 号不记入快照，下次同步时重试
+		snapshot := make([]string, 0, len(diff.remote))
+		for _, b := range diff.remote {
+			if !slices.Contains(failed, b) {
+				snapshot = append(snapshot, b)
+			}
+		}
+		now := time.Now()
+		link.RemoteBVIDs = snapshot
+		link.LastSyncAt = &now
+		link.LastAdded = result.Added
+		link.LastRemoved = result.Removed
+		return tx.Save(&link).Error
+	})
+	if err != nil {
+		return result, err
+	}
+	result.Link = link
+	return result, nil
+}
+
+// ConfirmBiliFavoritePush pushes a favorite whose first push sync needed
+// confirmation. Only the remote-only videos listed in removals are deleted
+// from the folder; the others are kept there.
+func (s *Service) ConfirmBiliFavoritePush(favoriteID string, removals []string) (BiliFavoriteSyncResult, error) {
+	result := BiliFavoriteSyncResult{Policy: models.BiliSyncPush}
+	link, err := getBiliFavoriteLink(s.db, favoriteID)
+	if err != nil {
+		return result, err
+	}
+	if biliLinkKind(link) == models.BiliCollectionSeason {
+		return result, fmt.Errorf("合集不支持写回")
+	}
+	if result.Diff, err = s.diffBiliFavorite(link); err != nil {
+		return result, err
+	}
+	return s.pushBiliFavorite(link, result, removals)
+}
+
+// pushBiliFavorite makes the remote folder match the favorite: local
+// additions and remote removals are added to the folder, remote additions and
+// local removals are removed from it. On the first sync there is no record
+// of what was removed locally, so remote additions are only removed when
+// listed in confirmed. Videos that fail are reported and left as they are.
+func (s *Service) pushBiliFavorite(link models.BiliFavoriteLink, result BiliFavoriteSyncResult, confirmed []string) (BiliFavoriteSyncResult, error) {
+	diff := result.Diff
+	if _, err := s.biliCSRF(); err != nil {
+		return result, err
+	}
+	result.Failed = []string{}
+	remote := make(map[string]bool, len(diff.remote))
+	for _, b := range diff.remote {
+		remote[b] = true
+	}
+
+	for _, bvid := range append(append([]string{}, diff.LocalAdded...), diff.RemoteRemoved...) {
+		info, err := s.getVideoInfo(bvid)
+		if err == nil {
+			err = s.dealBiliFavorite(info.Aid, []int64{link.MediaID}, nil)
+		}
+		if err != nil {
+			if isBiliAuthError(err) {
+				return result, err
+			}
+			result.Failed = append(result.Failed, bvid)
+			continue
+		}
+		remote[bvid] = true
+		result.PushedAdded++
+	}
+	for _, bvid := range append(append([]string{}, diff.RemoteAdded...), diff.LocalRemoved...) {
+		if diff.FirstSync && !slices.Contains(confirmed, bvid) {
+			continue
+		}
+		if err := s.dealBiliFavorite(diff.remoteAIDs[bvid], nil, []int64{link.MediaID}); err != nil {
+			if isBiliAuthError(err) {
+				return result, err
+			}
+			result.Failed = append(result.Failed, bvid)
+			continue
+		}
+		delete(remote, bvid)
+		result.PushedRemoved++
+	}
+
+	snapshot := make([]string, 0, len(remote))
+	for b := range remote {
+		snapshot = append(snapshot, b)
+	}
+	slices.Sort(snapshot)
+	now := time.Now()
+	link.RemoteBVIDs = snapshot
+	link.LastSyncAt = &now
+	link.LastAdded = 0
+	link.LastRemoved = 0
+	if err := s.db.Save(&link).Error; err != nil {
+		return result, err
+	}
+	result.Link = link
+	return result, nil
+}
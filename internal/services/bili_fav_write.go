@@ -0,0 +1,185 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"half-beat-player/internal/models"
+)
+
+// 收藏相关接口的错误码
+const (
+	biliCodeNotLoggedIn    = -101
+	biliCodeCSRFFailed     = -111
+	biliCodeAlreadyFaved   = 11201
+	biliCodeAlreadyUnfaved = 11202
+)
+
+var biliFavErrorMessages = map[int]string{
+	biliCodeNotLoggedIn: "未登录或登录已失效",
+	biliCodeCSRFFailed:  "CSRF 校验失败，请重新登录",
+	-400:                "请求错误",
+	-403:                "访问权限不足",
+	-404:                "内容不存在",
+	10003:               "视频不存在",
+	11203:               "收藏数量已达上限",
+}
+
+// biliFavError is an error code returned by a Bilibili favorite API.
+type biliFavError struct {
+	Code int
+	Msg  string
+}
+
+func (e *biliFavError) Error() string {
+	if known, ok := biliFavErrorMessages[e.Code]; ok {
+		return fmt.Sprintf("%s (code=%d)", known, e.Code)
+	}
+	msg := e.Msg
+	if msg == "" {
+		msg = "未知错误"
+	}
+	return fmt.Sprintf("API 错误 (code=%d): %s", e.Code, msg)
+}
+
+// isBiliAuthError reports whether err means the login or CSRF token is no
+// longer valid, so retrying other items is pointless.
+func isBiliAuthError(err error) bool {
+	var apiErr *biliFavError
+	return errors.As(err, &apiErr) && (apiErr.Code == biliCodeNotLoggedIn || apiErr.Code == biliCodeCSRFFailed)
+}
+
+// biliCSRF returns the bili_jct cookie required by write APIs.
+func (s *Service) biliCSRF() (string, error) {
+	if !s.IsLoggedIn() {
+		return "", fmt.Errorf("未登录")
+	}
+	for _, c := range s.cookieJar.Cookies(&url.URL{Scheme: "https", Host: "www.bilibili.com"}) {
+		if c.Name == "bili_jct" && c.Value != "" {
+			return c.Value, nil
+		}
+	}
+	return "", fmt.Errorf("缺少 bili_jct，请重新登录后再试")
+}
+
+// postBiliForm posts a form with the CSRF token and decodes data into out.
+func (s *Service) postBiliForm(endpoint string, form url.Values, out any) error {
+	csrf, err := s.biliCSRF()
+	if err != nil {
+		return err
+	}
+	form.Set("csrf", csrf)
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36")
+	req.Header.Set("Referer", "https://www.bilibili.com/")
+	req.Header.Set("Origin", "https://www.bilibili.com")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取响应失败: %w", err)
+	}
+
+	var res struct {
+		Code int             `json:"code"`
+		Msg  string          `json:"message"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return fmt.Errorf("解析响应失败: %w, body: %s", err, string(body[:min(len(body), 200)]))
+	}
+	if res.Code != 0 {
+		return &biliFavError{Code: res.Code, Msg: res.Msg}
+	}
+	if out != nil && len(res.Data) > 0 {
+		if err := json.Unmarshal(res.Data, out); err != nil {
+			return fmt.Errorf("解析响应失败: %w", err)
+		}
+	}
+	return nil
+}
+
+func joinMediaIDs(ids []int64) string {
+	parts := make([]string, 0, len(ids))
+	for _, id := range ids {
+		parts = append(parts, strconv.FormatInt(id, 10))
+	}
+	return strings.Join(parts, ",")
+}
+
+// dealBiliFavorite adds video aid to and removes it from Bilibili folders.
+// Adding a video twice or removing it twice is not an error.
+func (s *Service) dealBiliFavorite(aid int64, addMediaIDs, delMediaIDs []int64) error {
+	if len(addMediaIDs) == 0 && len(delMediaIDs) == 0 {
+		return nil
+	}
+	form := url.Values{}
+	form.Set("rid", strconv.FormatInt(aid, 10))
+	form.Set("type", "2")
+	form.Set("add_media_ids", joinMediaIDs(addMediaIDs))
+	form.Set("del_media_ids", joinMediaIDs(delMediaIDs))
+	err := s.postBiliForm("https://api.bilibili.com/x/v3/fav/resource/deal", form, nil)
+	var apiErr *biliFavError
+	if errors.As(err, &apiErr) && (apiErr.Code == biliCodeAlreadyFaved || apiErr.Code == biliCodeAlreadyUnfaved) {
+		return nil
+	}
+	return err
+}
+
+// SetBiliVideoFavorite adds a video to the Bilibili folders addMediaIDs and
+// removes it from delMediaIDs. Requires login.
+func (s *Service) SetBiliVideoFavorite(bvid string, addMediaIDs []int64, delMediaIDs []int64) error {
+	bvid = extractBVID(bvid)
+	if bvid == "" {
+		return fmt.Errorf("invalid BVID format")
+	}
+	info, err := s.getVideoInfo(bvid)
+	if err != nil {
+		return err
+	}
+	return s.dealBiliFavorite(info.Aid, addMediaIDs, delMediaIDs)
+}
+
+// CreateBiliFavoriteFolder creates a folder in the logged in account.
+func (s *Service) CreateBiliFavoriteFolder(title string, intro string, private bool) (models.BiliFavoriteCollection, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return models.BiliFavoriteCollection{}, fmt.Errorf("收藏夹名称不能为空")
+	}
+	form := url.Values{}
+	form.Set("title", title)
+	form.Set("intro", intro)
+	form.Set("privacy", "0")
+	if private {
+		form.Set("privacy", "1")
+	}
+	var data struct {
+		ID         int64  `json:"id"`
+		Title      string `json:"title"`
+		Cover      string `json:"cover"`
+		MediaCount int    `json:"media_count"`
+	}
+	if err := s.postBiliForm("https://api.bilibili.com/x/v3/fav/folder/add", form, &data); err != nil {
+		return models.BiliFavoriteCollection{}, err
+	}
+	return models.BiliFavoriteCollection{
+		ID:    data.ID,
+		Title: data.Title,
+		Count: data.MediaCount,
+		Cover: data.Cover,
+	}, nil
+}
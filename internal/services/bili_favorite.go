@@ -11,67 +11,76 @@ import (
 
 // GetMyFavoriteCollections 获取当前登录用户的收藏夹列表
 func (s *Service) GetMyFavoriteCollections() ([]models.BiliFavoriteCollection, error) {
-	if !s.IsLoggedIn() {
-		return nil, fmt.Errorf("未登录")
-	}
+	return s.listMyFavoriteCollections(0)
+}
 
-	user, err := s.GetUserInfo()
-	if err != nil {
-		return nil, fmt.Errorf("获取用户信息失败: %w", err)
+// GetMyFavoriteCollectionsForVideo 获取当前登录用户的收藏夹列表，并标记指定视频是否已在各收藏夹中
+func (s *Service) GetMyFavoriteCollectionsForVideo(bvid string) ([]models.BiliFavoriteCollection, error) {
+	bvid = extractBVID(bvid)
+	if bvid == "" {
+		return nil, fmt.Errorf("invalid BVID format")
 	}
-
-	endpoint := fmt.Sprintf("https://api.bilibili.com/x/v3/fav/folder/created/list?up_mid=%d&pn=1&ps=100", user.UID)
-	req, err := http.NewRequest("GET", endpoint, nil)
+	info, err := s.getVideoInfo(bvid)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("User-Agent", "Mozilla/5.0")
-	req.Header.Set("Referer", "https://www.bilibili.com/")
+	return s.listMyFavoriteCollections(info.Aid)
+}
 
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("请求失败: %w", err)
+// listMyFavoriteCollections 列出自己创建的收藏夹；rid 不为 0 时同时返回该视频的收藏状态
+func (s *Service) listMyFavoriteCollections(rid int64) ([]models.BiliFavoriteCollection, error) {
+	if !s.IsLoggedIn() {
+		return nil, fmt.Errorf("未登录")
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	user, err := s.GetUserInfo()
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("获取用户信息失败: %w", err)
 	}
+	return s.listCreatedFavoriteCollections(user.UID, rid)
+}
 
-	var res struct {
-		Code int    `json:"code"`
-		Msg  string `json:"message"`
-		Data struct {
+// listCreatedFavoriteCollections 分页列出用户创建的收藏夹；他人只能看到公开的收藏夹
+func (s *Service) listCreatedFavoriteCollections(mid int64, rid int64) ([]models.BiliFavoriteCollection, error) {
+	out := []models.BiliFavoriteCollection{}
+	for pn := 1; ; pn++ {
+		endpoint := fmt.Sprintf("https://api.bilibili.com/x/v3/fav/folder/created/list?up_mid=%d&pn=%d&ps=50", mid, pn)
+		if rid != 0 {
+			endpoint += fmt.Sprintf("&type=2&rid=%d", rid)
+		}
+		var data struct {
 			List []struct {
 				ID         int64  `json:"id"`
 				Title      string `json:"title"`
 				MediaCount int    `json:"media_count"`
 				Cover      string `json:"cover"`
+				FavState   int    `json:"fav_state"`
+				Upper      struct {
+					Mid  int64  `json:"mid"`
+					Name string `json:"name"`
+				} `json:"upper"`
 			} `json:"list"`
-		} `json:"data"`
-	}
-
-	if err := json.Unmarshal(body, &res); err != nil {
-		return nil, fmt.Errorf("解析响应失败: %w, body: %s", err, string(body))
-	}
-
-	if res.Code != 0 {
-		msg := res.Msg
-		if msg == "" {
-			msg = "未知错误"
+			HasMore bool `json:"has_more"`
+		}
+		if err := s.getBiliJSON(endpoint, &data); err != nil {
+			return nil, err
+		}
+		for _, it := range data.List {
+			out = append(out, models.BiliFavoriteCollection{
+				ID:       it.ID,
+				Kind:     models.BiliCollectionFolder,
+				Title:    it.Title,
+				Count:    it.MediaCount,
+				Cover:    it.Cover,
+				Upper:    it.Upper.Name,
+				UpperMID: it.Upper.Mid,
+				HasVideo: rid != 0 && it.FavState == 1,
+			})
+		}
+		// 分页获取，避免收藏夹较多时只拿到第一页
+		if !data.HasMore || len(data.List) == 0 {
+			break
 		}
-		return nil, fmt.Errorf("API 错误: %d (%s)", res.Code, msg)
-	}
-
-	var out []models.BiliFavoriteCollection
-	for _, it := range res.Data.List {
-		out = append(out, models.BiliFavoriteCollection{
-			ID:    it.ID,
-			Title: it.Title,
-			Count: it.MediaCount,
-			Cover: it.Cover,
-		})
 	}
 	return out, nil
 }
@@ -140,6 +149,18 @@ func (s *Service) GetFavoriteCollectionInfo(mediaID int64) (*models.BiliFavorite
 // GetFavoriteCollectionBVIDs 获取指定收藏夹的所有 BVID（公开收藏夹可用，无需登录）
 // 使用 /x/v3/fav/resource/ids API，一次性获取所有内容ID
 func (s *Service) GetFavoriteCollectionBVIDs(mediaID int64) ([]models.BiliFavoriteInfo, error) {
+	result, err := s.fetchFavoriteResourceIDs(mediaID)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("收藏夹为空或不存在")
+	}
+	return result, nil
+}
+
+// fetchFavoriteResourceIDs 获取收藏夹中全部视频的 BVID，空收藏夹返回空列表
+func (s *Service) fetchFavoriteResourceIDs(mediaID int64) ([]models.BiliFavoriteInfo, error) {
 	endpoint := fmt.Sprintf("https://api.bilibili.com/x/v3/fav/resource/ids?media_id=%d&platform=web", mediaID)
 
 	req, err := http.NewRequest("GET", endpoint, nil)
@@ -191,12 +212,8 @@ func (s *Service) GetFavoriteCollectionBVIDs(mediaID int64) ([]models.BiliFavori
 		return nil, fmt.Errorf("API 错误 (code=%d): %s", res.Code, msg)
 	}
 
-	if len(res.Data) == 0 {
-		return nil, fmt.Errorf("收藏夹为空或不存在")
-	}
-
 	// 只返回视频类型的内容（type=2），过滤音频和视频合集
-	var result []models.BiliFavoriteInfo
+	result := []models.BiliFavoriteInfo{}
 	for _, item := range res.Data {
 		if item.Type != 2 {
 			continue
@@ -209,6 +226,7 @@ func (s *Service) GetFavoriteCollectionBVIDs(mediaID int64) ([]models.BiliFavori
 
 		if bvid != "" {
 			result = append(result, models.BiliFavoriteInfo{
+				AID:   item.ID,
 				BVID:  bvid,
 				Title: "", // ids 接口不返回标题，需要后续通过解析 BV 号获取
 				Cover: "", // ids 接口不返回封面
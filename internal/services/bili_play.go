@@ -20,11 +20,14 @@ type PlayInfo struct {
 	ExpiresAt time.Time
 	Title     string
 	Duration  int64
+	Loudness  *models.AudioLoudness // 可能为空：部分稿件没有响度数据
 }
 
 // VideoInfo holds Bilibili video metadata.
 type VideoInfo struct {
+	Aid      int64
 	Title    string
+	Desc     string
 	Cover    string
 	Duration int64
 	Author   string
@@ -47,7 +50,7 @@ func (s *Service) GetPlayURL(bvid string, p int) (PlayInfo, error) {
 	}
 
 	// Step 2: Get playurl
-	audioURL, exp, err := s.getAudioURL(bvid, cid)
+	audioURL, exp, loudness, err := s.getAudioURL(bvid, cid)
 	if err != nil {
 		// Check if login error
 		if err.Error() != "" {
@@ -58,12 +61,24 @@ func (s *Service) GetPlayURL(bvid string, p int) (PlayInfo, error) {
 
 	proxyURL := s.getAudioProxyURL(audioURL)
 
+	if loudness != nil {
+		loudness.ID = loudnessID(bvid, p)
+		loudness.BVID = bvid
+		loudness.Page = p
+		loudness.Cid = cid
+		// 响度数据仅用于建议增益，保存失败不影响播放
+		if err := s.saveAudioLoudness(loudness); err != nil {
+			fmt.Printf("[Loudness] 保存响度数据失败: %v\n", err)
+		}
+	}
+
 	return PlayInfo{
 		RawURL:    audioURL,
 		ProxyURL:  proxyURL,
 		ExpiresAt: exp,
 		Title:     title,
 		Duration:  duration,
+		Loudness:  loudness,
 	}, nil
 }
 
@@ -107,7 +122,7 @@ func (s *Service) getCidFromBVID(bvid string, p int) (int64, string, int64, erro
 	return page.Cid, page.Part, page.Duration, nil
 }
 
-func (s *Service) getAudioURL(bvid string, cid int64) (string, time.Time, error) {
+func (s *Service) getAudioURL(bvid string, cid int64) (string, time.Time, *models.AudioLoudness, error) {
 	endpoint := fmt.Sprintf("https://api.bilibili.com/x/player/playurl?bvid=%s&cid=%d&fnval=4048", bvid, cid)
 	req, _ := http.NewRequest("GET", endpoint, nil)
 	req.Header.Set("User-Agent", "Mozilla/5.0")
@@ -115,7 +130,7 @@ func (s *Service) getAudioURL(bvid string, cid int64) (string, time.Time, error)
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
-		return "", time.Time{}, fmt.Errorf("playurl request error: %w", err)
+		return "", time.Time{}, nil, fmt.Errorf("playurl request error: %w", err)
 	}
 	defer resp.Body.Close()
 
@@ -129,17 +144,25 @@ func (s *Service) getAudioURL(bvid string, cid int64) (string, time.Time, error)
 					BackupURL []string `json:"backup_url"`
 				} `json:"audio"`
 			} `json:"dash"`
+			Volume *struct {
+				MeasuredI         float64 `json:"measured_i"`
+				MeasuredLRA       float64 `json:"measured_lra"`
+				MeasuredTP        float64 `json:"measured_tp"`
+				MeasuredThreshold float64 `json:"measured_threshold"`
+				TargetOffset      float64 `json:"target_offset"`
+				TargetI           float64 `json:"target_i"`
+			} `json:"volume"`
 		} `json:"data"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
-		return "", time.Time{}, fmt.Errorf("playurl decode error: %w", err)
+		return "", time.Time{}, nil, fmt.Errorf("playurl decode error: %w", err)
 	}
 	if res.Code != 0 {
-		return "", time.Time{}, fmt.Errorf("playurl API error: code=%d, msg=%s", res.Code, res.Msg)
+		return "", time.Time{}, nil, fmt.Errorf("playurl API error: code=%d, msg=%s", res.Code, res.Msg)
 	}
 
 	if len(res.Data.DASH.Audio) == 0 {
-		return "", time.Time{}, fmt.Errorf("no audio track found in DASH data")
+		return "", time.Time{}, nil, fmt.Errorf("no audio track found in DASH data")
 	}
 
 	audio := res.Data.DASH.Audio[0]
@@ -148,11 +171,24 @@ func (s *Service) getAudioURL(bvid string, cid int64) (string, time.Time, error)
 		audioURL = audio.BackupURL[0]
 	}
 	if audioURL == "" {
-		return "", time.Time{}, fmt.Errorf("no playable audio URL in audio track")
+		return "", time.Time{}, nil, fmt.Errorf("no playable audio URL in audio track")
 	}
 
 	exp := deriveExpireTime(audioURL)
-	return audioURL, exp, nil
+
+	var loudness *models.AudioLoudness
+	if v := res.Data.Volume; v != nil && v.MeasuredI != 0 {
+		loudness = &models.AudioLoudness{
+			MeasuredI:      v.MeasuredI,
+			MeasuredLRA:    v.MeasuredLRA,
+			MeasuredTP:     v.MeasuredTP,
+			MeasuredThresh: v.MeasuredThreshold,
+			TargetI:        v.TargetI,
+			TargetOffset:   v.TargetOffset,
+		}
+		loudness.SuggestedGainDb = suggestedGainDb(*loudness)
+	}
+	return audioURL, exp, loudness, nil
 }
 
 func (s *Service) getVideoInfo(bvid string) (VideoInfo, error) {
@@ -171,7 +207,9 @@ func (s *Service) getVideoInfo(bvid string) (VideoInfo, error) {
 		Code int    `json:"code"`
 		Msg  string `json:"message"`
 		Data struct {
+			Aid      int64  `json:"aid"`
 			Title    string `json:"title"`
+			Desc     string `json:"desc"`
 			Pic      string `json:"pic"`
 			Duration int64  `json:"duration"`
 			Owner    struct {
@@ -202,7 +240,9 @@ func (s *Service) getVideoInfo(bvid string) (VideoInfo, error) {
 	author := strings.Join(authors, "; ")
 
 	return VideoInfo{
+		Aid:      res.Data.Aid,
 		Title:    res.Data.Title,
+		Desc:     res.Data.Desc,
 		Cover:    normalizeBiliPic(res.Data.Pic),
 		Duration: res.Data.Duration,
 		Author:   author,
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WBI 签名相关常量
+const (
+	wbiNavEndpoint = "https://api.bilibili.com/x/web-interface/nav"
+	// B 站每天轮换 img_key/sub_key，这里保守地每 6 小时刷新一次
+	wbiKeyTTL = 6 * time.Hour
+)
+
+// mixinKeyEncTab 是 B 站前端用于打乱 img_key+sub_key 的固定置换表。
+var mixinKeyEncTab = []int{
+	46, 47, 18, 2, 53, 8, 23, 32, 15, 50, 10, 31, 58, 3, 45, 35, 27, 43, 5, 49,
+	33, 9, 42, 19, 29, 28, 14, 39, 12, 38, 41, 13, 37, 48, 7, 16, 24, 55, 40,
+	61, 26, 17, 0, 1, 60, 51, 30, 4, 22, 25, 54, 21, 56, 59, 6, 63, 57, 62, 11,
+	36, 20, 34, 44, 52,
+}
+
+// wbiKeyCache caches the mixin key derived from the nav endpoint.
+type wbiKeyCache struct {
+	mu        sync.Mutex
+	mixinKey  string
+	fetchedAt time.Time
+}
+
+// getWBIMixinKey returns the cached mixin key, fetching fresh keys when missing or stale.
+func (s *Service) getWBIMixinKey() (string, error) {
+	s.wbi.mu.Lock()
+	defer s.wbi.mu.Unlock()
+
+	if s.wbi.mixinKey != "" && time.Since(s.wbi.fetchedAt) < wbiKeyTTL {
+		return s.wbi.mixinKey, nil
+	}
+
+	imgKey, subKey, err := s.fetchWBIKeys()
+	if err != nil {
+		return "", err
+	}
+	s.wbi.mixinKey = getMixinKey(imgKey + subKey)
+	s.wbi.fetchedAt = time.Now()
+	return s.wbi.mixinKey, nil
+}
+
+// invalidateWBIKeys drops the cached mixin key so the next request refetches it.
+func (s *Service) invalidateWBIKeys() {
+	s.wbi.mu.Lock()
+	s.wbi.mixinKey = ""
+	s.wbi.fetchedAt = time.Time{}
+	s.wbi.mu.Unlock()
+}
+
+// fetchWBIKeys reads img_key and sub_key from the nav endpoint.
+// The endpoint returns code -101 for anonymous users but still carries wbi_img.
+func (s *Service) fetchWBIKeys() (string, string, error) {
+	req, err := http.NewRequest("GET", wbiNavEndpoint, nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Referer", "https://www.bilibili.com/")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("wbi nav request error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		Code int `json:"code"`
+		Data struct {
+			WbiImg struct {
+				ImgURL string `json:"img_url"`
+				SubURL string `json:"sub_url"`
+			} `json:"wbi_img"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", "", fmt.Errorf("wbi nav decode error: %w", err)
+	}
+
+	imgKey := wbiKeyFromURL(res.Data.WbiImg.ImgURL)
+	subKey := wbiKeyFromURL(res.Data.WbiImg.SubURL)
+	if imgKey == "" || subKey == "" {
+		return "", "", fmt.Errorf("wbi keys missing in nav response: code=%d", res.Code)
+	}
+	return imgKey, subKey, nil
+}
+
+// wbiKeyFromURL extracts the key from a wbi image URL (file name without extension).
+func wbiKeyFromURL(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	base := path.Base(raw)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// getMixinKey shuffles the raw key with mixinKeyEncTab and keeps the first 32 chars.
+func getMixinKey(orig string) string {
+	var b strings.Builder
+	for _, idx := range mixinKeyEncTab {
+		if idx < len(orig) {
+			b.WriteByte(orig[idx])
+		}
+	}
+	key := b.String()
+	if len(key) > 32 {
+		key = key[:32]
+	}
+	return key
+}
+
+// signWBIQuery adds wts and w_rid to params and returns the encoded query string.
+func signWBIQuery(params url.Values, mixinKey string, now time.Time) string {
+	signed := url.Values{}
+	for k, vs := range params {
+		for _, v := range vs {
+			// 签名前需去除值中的 !'()* 字符
+			signed.Add(k, strings.Map(func(r rune) rune {
+				if strings.ContainsRune("!'()*", r) {
+					return -1
+				}
+				return r
+			}, v))
+		}
+	}
+	signed.Set("wts", strconv.FormatInt(now.Unix(), 10))
+	signed.Del("w_rid")
+
+	// url.Values.Encode 已按 key 排序，但空格需编码为 %20
+	query := strings.ReplaceAll(signed.Encode(), "+", "%20")
+	sum := md5.Sum([]byte(query + mixinKey))
+	return query + "&w_rid=" + hex.EncodeToString(sum[:])
+}
+
+// signWBIParams returns params signed with the current WBI keys.
+func (s *Service) signWBIParams(params url.Values) (string, error) {
+	mixinKey, err := s.getWBIMixinKey()
+	if err != nil {
+		return "", fmt.Errorf("获取 WBI 密钥失败: %w", err)
+	}
+	return signWBIQuery(params, mixinKey, time.Now()), nil
+}
+
+// isWBIRejected reports whether a Bilibili response code means the signature was refused.
+func isWBIRejected(code int) bool {
+	return code == -403 || code == -352
+}
+
+// biliWBIGet performs a WBI-signed GET request and returns the response with its body.
+// When Bilibili rejects the signature the cached keys are dropped and the request
+// is retried once with freshly fetched keys.
+func (s *Service) biliWBIGet(api string, params url.Values, header http.Header) (*http.Response, []byte, error) {
+	client := s.httpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	var (
+		resp *http.Response
+		body []byte
+	)
+	for attempt := 0; attempt < 2; attempt++ {
+		query, err := s.signWBIParams(params)
+		if err != nil {
+			return nil, nil, err
+		}
+		req, err := http.NewRequest("GET", api+"?"+query, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		req.Header = header.Clone()
+		if req.Header == nil {
+			req.Header = http.Header{}
+		}
+		if req.Header.Get("User-Agent") == "" {
+			req.Header.Set("User-Agent", "Mozilla/5.0")
+		}
+		if req.Header.Get("Referer") == "" {
+			req.Header.Set("Referer", "https://www.bilibili.com/")
+		}
+
+		resp, err = client.Do(req)
+		if err != nil {
+			return nil, nil, err
+		}
+		body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var probe struct {
+			Code int `json:"code"`
+		}
+		if json.Unmarshal(body, &probe) == nil && isWBIRejected(probe.Code) && attempt == 0 {
+			s.invalidateWBIKeys()
+			continue
+		}
+		break
+	}
+	return resp, body, nil
+}
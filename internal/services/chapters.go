@@ -0,0 +1,339 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"half-beat-player/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const playerV2Endpoint = "https://api.bilibili.com/x/player/wbi/v2"
+
+// 章节标题关键字（小写匹配）
+var (
+	chapterIntroKeywords = []string{"片头", "开场", "开头", "广告", "赞助", "intro", "opening"}
+	chapterMainKeywords  = []string{"正片", "本篇", "正式开始", "歌曲开始", "开唱", "song", "music"}
+	chapterOutroKeywords = []string{"片尾", "结尾", "彩蛋", "花絮", "感谢", "outro", "ending"}
+)
+
+// SkipSuggestion is a proposed skip window for one song, used for preview before saving.
+type SkipSuggestion struct {
+	SongID         string                `json:"songId"`
+	Name           string                `json:"name"`
+	CurrentStart   float64               `json:"currentStart"`
+	CurrentEnd     float64               `json:"currentEnd"`
+	SuggestedStart float64               `json:"suggestedStart"`
+	SuggestedEnd   float64               `json:"suggestedEnd"` // 0 表示播放到结尾
+	Reason         string                `json:"reason"`
+	Chapters       []models.VideoChapter `json:"chapters"`
+	Changed        bool                  `json:"changed"`
+}
+
+// playerV2Data is the subset of the player v2 response we consume.
+type playerV2Data struct {
+	ViewPoints []struct {
+		Type    int    `json:"type"`
+		From    int64  `json:"from"`
+		To      int64  `json:"to"`
+		Content string `json:"content"`
+	} `json:"view_points"`
+	Subtitle struct {
+		Subtitles []struct {
+			ID          int64  `json:"id"`
+			Lan         string `json:"lan"`
+			LanDoc      string `json:"lan_doc"`
+			SubtitleURL string `json:"subtitle_url"`
+			AiType      int    `json:"ai_type"`
+			AiStatus    int    `json:"ai_status"`
+		} `json:"subtitles"`
+	} `json:"subtitle"`
+}
+
+// fetchPlayerV2 queries the (WBI-signed) player v2 API for a video page.
+func (s *Service) fetchPlayerV2(bvid string, cid int64) (playerV2Data, error) {
+	q := url.Values{}
+	q.Set("bvid", bvid)
+	q.Set("cid", strconv.FormatInt(cid, 10))
+
+	header := http.Header{}
+	header.Set("User-Agent", "Mozilla/5.0")
+	header.Set("Referer", fmt.Sprintf("https://www.bilibili.com/video/%s", bvid))
+
+	resp, body, err := s.biliWBIGet(playerV2Endpoint, q, header)
+	if err != nil {
+		return playerV2Data{}, fmt.Errorf("player v2 request error: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return playerV2Data{}, fmt.Errorf("player v2 http %d", resp.StatusCode)
+	}
+
+	var res struct {
+		Code int          `json:"code"`
+		Msg  string       `json:"message"`
+		Data playerV2Data `json:"data"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return playerV2Data{}, fmt.Errorf("player v2 decode error: %w", err)
+	}
+	if res.Code != 0 {
+		return playerV2Data{}, fmt.Errorf("player v2 API error: code=%d, msg=%s", res.Code, res.Msg)
+	}
+	return res.Data, nil
+}
+
+// getVideoChapters returns chapter markers for a video page plus the page duration.
+func (s *Service) getVideoChapters(bvid string, page int) ([]models.VideoChapter, int64, error) {
+	if page <= 0 {
+		page = 1
+	}
+	cid, _, duration, err := s.getCidFromBVID(bvid, page)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, err := s.fetchPlayerV2(bvid, cid)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	chapters := make([]models.VideoChapter, 0, len(data.ViewPoints))
+	for _, vp := range data.ViewPoints {
+		// type=2 为 UP 主设置的分段章节，其余类型（如高能进度条）忽略
+		if vp.Type != 2 {
+			continue
+		}
+		chapters = append(chapters, models.VideoChapter{
+			From:    vp.From,
+			To:      vp.To,
+			Content: strings.TrimSpace(vp.Content),
+		})
+	}
+	return chapters, duration, nil
+}
+
+// GetSongChapters returns the chapter markers of the song's video page.
+func (s *Service) GetSongChapters(songID string) ([]models.VideoChapter, error) {
+	song, err := s.getSongByID(songID)
+	if err != nil {
+		return nil, err
+	}
+	if song.BVID == "" {
+		return nil, fmt.Errorf("歌曲缺少 BVID")
+	}
+	chapters, _, err := s.getVideoChapters(song.BVID, song.PageNumber)
+	return chapters, err
+}
+
+// SuggestSkipWindow proposes skip start/end times for a song from its chapters.
+// Nothing is saved; use ApplySkipSuggestions to persist.
+func (s *Service) SuggestSkipWindow(songID string) (SkipSuggestion, error) {
+	song, err := s.getSongByID(songID)
+	if err != nil {
+		return SkipSuggestion{}, err
+	}
+	return s.suggestSkipWindow(song)
+}
+
+func (s *Service) suggestSkipWindow(song models.Song) (SkipSuggestion, error) {
+	out := SkipSuggestion{
+		SongID:         song.ID,
+		Name:           song.Name,
+		CurrentStart:   song.SkipStartTime,
+		CurrentEnd:     song.SkipEndTime,
+		SuggestedStart: song.SkipStartTime,
+		SuggestedEnd:   song.SkipEndTime,
+	}
+	if song.BVID == "" {
+		out.Reason = "歌曲缺少 BVID"
+		return out, nil
+	}
+
+	chapters, duration, err := s.getVideoChapters(song.BVID, song.PageNumber)
+	if err != nil {
+		return out, err
+	}
+	out.Chapters = chapters
+
+	start, end, reason, ok := skipWindowFromChapters(chapters, duration)
+	if !ok {
+		out.Reason = reason
+		return out, nil
+	}
+	out.SuggestedStart = start
+	out.SuggestedEnd = end
+	out.Reason = reason
+	// 前端会把 0 自动替换为实际时长，比较时视为同一含义
+	currentEnd := song.SkipEndTime
+	if duration > 0 && currentEnd >= float64(duration)-1 {
+		currentEnd = 0
+	}
+	out.Changed = start != song.SkipStartTime || end != currentEnd
+	return out, nil
+}
+
+// skipWindowFromChapters derives a skip window from chapter titles.
+// A "main" chapter (正片 etc.) wins; otherwise leading intro chapters and
+// trailing outro chapters are trimmed. End 0 means "play to the end".
+func skipWindowFromChapters(chapters []models.VideoChapter, duration int64) (float64, float64, string, bool) {
+	if len(chapters) == 0 {
+		return 0, 0, "视频没有章节信息", false
+	}
+
+	normaliseEnd := func(to int64) float64 {
+		if duration > 0 && to >= duration {
+			return 0
+		}
+		return float64(to)
+	}
+
+	for _, ch := range chapters {
+		if chapterMatches(ch.Content, chapterMainKeywords) {
+			return float64(ch.From), normaliseEnd(ch.To), fmt.Sprintf("章节「%s」", ch.Content), true
+		}
+	}
+
+	var (
+		start   int64
+		end     int64
+		reasons []string
+	)
+	for _, ch := range chapters {
+		if !chapterMatches(ch.Content, chapterIntroKeywords) {
+			break
+		}
+		start = ch.To
+		reasons = append(reasons, fmt.Sprintf("跳过片头「%s」", ch.Content))
+	}
+	for i := len(chapters) - 1; i >= 0; i-- {
+		ch := chapters[i]
+		if ch.From <= start || !chapterMatches(ch.Content, chapterOutroKeywords) {
+			break
+		}
+		end = ch.From
+		reasons = append(reasons, fmt.Sprintf("跳过片尾「%s」", ch.Content))
+	}
+	if len(reasons) == 0 {
+		return 0, 0, "章节中没有可识别的片头/片尾", false
+	}
+	if end > 0 {
+		return float64(start), normaliseEnd(end), strings.Join(reasons, "，"), true
+	}
+	return float64(start), 0, strings.Join(reasons, "，"), true
+}
+
+func chapterMatches(content string, keywords []string) bool {
+	lower := strings.ToLower(content)
+	for _, kw := range keywords {
+		if strings.Contains(lower, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// PreviewFavoriteSkipWindows proposes skip windows for every song in a favorite.
+// Songs that already play only part of their page (see isSegmentSong) are
+// left out unless overwrite is true.
+func (s *Service) PreviewFavoriteSkipWindows(favoriteID string, overwrite bool) ([]SkipSuggestion, error) {
+	songs, err := s.getFavoriteSongs(favoriteID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]SkipSuggestion, 0, len(songs))
+	for _, song := range songs {
+		if !overwrite && isSegmentSong(song) {
+			continue
+		}
+		sug, err := s.suggestSkipWindow(song)
+		if err != nil {
+			sug.Reason = err.Error()
+			sug.Changed = false
+		}
+		out = append(out, sug)
+	}
+	return out, nil
+}
+
+// ApplySkipSuggestions saves the suggested windows of the given (confirmed) suggestions.
+// Only entries marked Changed are written. Returns the number of songs updated.
+func (s *Service) ApplySkipSuggestions(suggestions []SkipSuggestion) (int, error) {
+	updated := 0
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, sug := range suggestions {
+			if !sug.Changed || sug.SongID == "" {
+				continue
+			}
+			res := tx.Model(&models.Song{}).Where("id = ?", sug.SongID).Updates(map[string]any{
+				"skip_start_time": sug.SuggestedStart,
+				"skip_end_time":   sug.SuggestedEnd,
+			})
+			if res.Error != nil {
+				return res.Error
+			}
+			updated += int(res.RowsAffected)
+		}
+		return nil
+	})
+	return updated, err
+}
+
+func (s *Service) getSongByID(songID string) (models.Song, error) {
+	var song models.Song
+	if err := s.db.First(&song, "id = ?", songID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return song, fmt.Errorf("未找到歌曲: %s", songID)
+		}
+		return song, fmt.Errorf("查询歌曲失败: %w", err)
+	}
+	return song, nil
+}
+
+// getFavoriteSongs returns the songs of a favorite in ref order.
+func (s *Service) getFavoriteSongs(favoriteID string) ([]models.Song, error) {
+	var fav models.Favorite
+	if err := s.db.Where("id = ?", favoriteID).Limit(1).Find(&fav).Error; err != nil {
+		return nil, err
+	}
+	if fav.Kind == models.FavoriteKindSmart {
+		if fav.Rules == nil {
+			return []models.Song{}, nil
+		}
+		return s.evaluateSmartRules(s.db, *fav.Rules)
+	}
+
+	var refs []models.SongRef
+	if err := s.db.Where("favorite_id = ?", favoriteID).Order("position, id").Find(&refs).Error; err != nil {
+		return nil, err
+	}
+	if len(refs) == 0 {
+		return []models.Song{}, nil
+	}
+
+	ids := make([]string, 0, len(refs))
+	for _, r := range refs {
+		ids = append(ids, r.SongID)
+	}
+	var songs []models.Song
+	if err := s.db.Where("id IN ?", ids).Find(&songs).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[string]models.Song, len(songs))
+	for _, song := range songs {
+		byID[song.ID] = song
+	}
+
+	out := make([]models.Song, 0, len(refs))
+	for _, r := range refs {
+		if song, ok := byID[r.SongID]; ok {
+			out = append(out, song)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,404 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"half-beat-player/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// cue 时间以帧为单位，每秒 75 帧
+const cueFramesPerSecond = 75
+
+// CueSheet is a parsed .cue file.
+type CueSheet struct {
+	Title     string     `json:"title"`
+	Performer string     `json:"performer"`
+	File      string     `json:"file"`
+	Tracks    []CueTrack `json:"tracks"`
+}
+
+// CueTrack is one TRACK entry of a cue sheet. Times are in seconds.
+type CueTrack struct {
+	Number    int     `json:"number"`
+	Title     string  `json:"title"`
+	Performer string  `json:"performer"`
+	Index00   float64 `json:"index00"` // 预留间隙起点，-1 表示无
+	Index01   float64 `json:"index01"`
+}
+
+// CueImportRequest imports a cue sheet against a Bilibili page or a local audio file.
+// Either CueText or CuePath must be set; either BVID or AudioPath (or a FILE line
+// resolvable next to CuePath) must be available.
+type CueImportRequest struct {
+	CueText    string `json:"cueText"`
+	CuePath    string `json:"cuePath"`
+	BVID       string `json:"bvid"`
+	Page       int    `json:"page"`
+	AudioPath  string `json:"audioPath"`
+	FavoriteID string `json:"favoriteId"`
+}
+
+// ParseCueSheet parses cue text for preview.
+func (s *Service) ParseCueSheet(text string) (CueSheet, error) {
+	return parseCueSheet(strings.NewReader(text))
+}
+
+func parseCueSheet(r io.Reader) (CueSheet, error) {
+	var sheet CueSheet
+	var cur *CueTrack
+	files := 0
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" {
+			continue
+		}
+		cmd, rest := splitCueCommand(line)
+		switch cmd {
+		case "TITLE":
+			if cur != nil {
+				cur.Title = unquoteCue(rest)
+			} else {
+				sheet.Title = unquoteCue(rest)
+			}
+		case "PERFORMER":
+			if cur != nil {
+				cur.Performer = unquoteCue(rest)
+			} else {
+				sheet.Performer = unquoteCue(rest)
+			}
+		case "FILE":
+			// FILE "name" TYPE
+			if i := strings.LastIndex(rest, " "); i > 0 && !strings.HasSuffix(rest, "\"") {
+				rest = rest[:i]
+			}
+			// 每个 FILE 的 INDEX 时间都从 0 重新计算，无法映射到同一条音频上
+			files++
+			if files > 1 {
+				return sheet, fmt.Errorf("第 %d 行: 暂不支持包含多个 FILE 的 cue", lineNo)
+			}
+			sheet.File = unquoteCue(rest)
+		case "TRACK":
+			fields := strings.Fields(rest)
+			if len(fields) == 0 {
+				return sheet, fmt.Errorf("第 %d 行: TRACK 缺少编号", lineNo)
+			}
+			n, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return sheet, fmt.Errorf("第 %d 行: 无效的音轨编号 %q", lineNo, fields[0])
+			}
+			sheet.Tracks = append(sheet.Tracks, CueTrack{Number: n, Index00: -1, Index01: -1})
+			cur = &sheet.Tracks[len(sheet.Tracks)-1]
+		case "INDEX":
+			if cur == nil {
+				return sheet, fmt.Errorf("第 %d 行: INDEX 不在 TRACK 内", lineNo)
+			}
+			fields := strings.Fields(rest)
+			if len(fields) != 2 {
+				return sheet, fmt.Errorf("第 %d 行: INDEX 格式错误", lineNo)
+			}
+			t, err := parseCueTime(fields[1])
+			if err != nil {
+				return sheet, fmt.Errorf("第 %d 行: %w", lineNo, err)
+			}
+			switch fields[0] {
+			case "00":
+				cur.Index00 = t
+			case "01":
+				cur.Index01 = t
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return sheet, err
+	}
+	if len(sheet.Tracks) == 0 {
+		return sheet, fmt.Errorf("cue 中没有 TRACK")
+	}
+	for _, t := range sheet.Tracks {
+		if t.Index01 < 0 {
+			return sheet, fmt.Errorf("音轨 %d 缺少 INDEX 01", t.Number)
+		}
+	}
+	return sheet, nil
+}
+
+func splitCueCommand(line string) (string, string) {
+	i := strings.IndexByte(line, ' ')
+	if i < 0 {
+		return strings.ToUpper(line), ""
+	}
+	return strings.ToUpper(line[:i]), strings.TrimSpace(line[i+1:])
+}
+
+func unquoteCue(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// parseCueTime parses mm:ss:ff into seconds.
+func parseCueTime(s string) (float64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("无效的 cue 时间 %q", s)
+	}
+	var v [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("无效的 cue 时间 %q", s)
+		}
+		v[i] = n
+	}
+	if v[1] >= 60 || v[2] >= cueFramesPerSecond {
+		return 0, fmt.Errorf("无效的 cue 时间 %q", s)
+	}
+	return float64(v[0]*60+v[1]) + float64(v[2])/cueFramesPerSecond, nil
+}
+
+func formatCueTime(sec float64) string {
+	frames := int(sec*cueFramesPerSecond + 0.5)
+	mm := frames / (60 * cueFramesPerSecond)
+	ss := (frames / cueFramesPerSecond) % 60
+	ff := frames % cueFramesPerSecond
+	return fmt.Sprintf("%02d:%02d:%02d", mm, ss, ff)
+}
+
+// segments maps cue tracks onto skip windows. A track ends at the next
+// track's INDEX 00 (pregap) if present, otherwise at its INDEX 01.
+func (c CueSheet) segments() []TrackSegment {
+	segs := make([]TrackSegment, 0, len(c.Tracks))
+	for i, t := range c.Tracks {
+		seg := TrackSegment{Start: t.Index01, Title: t.Title, Singer: t.Performer}
+		if seg.Singer == "" {
+			seg.Singer = c.Performer
+		}
+		if i+1 < len(c.Tracks) {
+			next := c.Tracks[i+1]
+			seg.End = next.Index01
+			if next.Index00 >= 0 {
+				seg.End = next.Index00
+			}
+		}
+		segs = append(segs, seg)
+	}
+	return segs
+}
+
+// ImportCueSheet creates songs for every cue track and appends them to the favorite.
+func (s *Service) ImportCueSheet(req CueImportRequest) ([]models.Song, error) {
+	var (
+		sheet CueSheet
+		err   error
+	)
+	switch {
+	case req.CueText != "":
+		sheet, err = parseCueSheet(strings.NewReader(req.CueText))
+	case req.CuePath != "":
+		f, openErr := os.Open(req.CuePath)
+		if openErr != nil {
+			return nil, fmt.Errorf("打开 cue 文件失败: %w", openErr)
+		}
+		defer f.Close()
+		sheet, err = parseCueSheet(f)
+	default:
+		return nil, fmt.Errorf("缺少 cue 内容")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("解析 cue 失败: %w", err)
+	}
+
+	if bvid := extractBVID(req.BVID); bvid != "" {
+		return s.SplitVideoIntoSongs(SplitRequest{
+			BVID:       bvid,
+			Page:       req.Page,
+			FavoriteID: req.FavoriteID,
+			Segments:   sheet.segments(),
+		})
+	}
+
+	audioPath := req.AudioPath
+	if audioPath == "" && req.CuePath != "" && sheet.File != "" {
+		audioPath = filepath.Join(filepath.Dir(req.CuePath), sheet.File)
+	}
+	if audioPath == "" {
+		return nil, fmt.Errorf("需要 BVID 或本地音频文件")
+	}
+	return s.importCueLocal(sheet, audioPath, req.FavoriteID)
+}
+
+// importCueLocal copies a local audio file into the downloads directory so the
+// local proxy can serve it, then creates one song per cue track.
+func (s *Service) importCueLocal(sheet CueSheet, audioPath, favoriteID string) ([]models.Song, error) {
+	if _, err := os.Stat(audioPath); err != nil {
+		return nil, fmt.Errorf("音频文件不可用: %w", err)
+	}
+
+	dstDir := filepath.Join(s.dataDir, downloadsDir)
+	if err := os.MkdirAll(dstDir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建下载目录失败: %w", err)
+	}
+	fileName := "local-" + uuid.NewString() + strings.ToLower(filepath.Ext(audioPath))
+	if err := copyFile(audioPath, filepath.Join(dstDir, fileName)); err != nil {
+		return nil, fmt.Errorf("复制音频文件失败: %w", err)
+	}
+
+	streamURL := s.getLocalProxyURL(fileName)
+	// 本地文件不会过期
+	expiresAt := time.Now().AddDate(100, 0, 0)
+	videoTitle := sheet.Title
+	if videoTitle == "" {
+		videoTitle = strings.TrimSuffix(filepath.Base(audioPath), filepath.Ext(audioPath))
+	}
+
+	songs := make([]models.Song, 0, len(sheet.Tracks))
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		source := models.StreamSource{ID: uuid.NewString(), StreamURL: streamURL, ExpiresAt: expiresAt}
+		if err := tx.Create(&source).Error; err != nil {
+			return err
+		}
+		for i, seg := range sheet.segments() {
+			name := strings.TrimSpace(seg.Title)
+			if name == "" {
+				name = fmt.Sprintf("%s #%d", videoTitle, i+1)
+			}
+			songs = append(songs, models.Song{
+				ID:                 uuid.NewString(),
+				Name:               name,
+				Singer:             seg.Singer,
+				SourceID:           source.ID,
+				StreamURL:          streamURL,
+				StreamURLExpiresAt: expiresAt,
+				SkipStartTime:      seg.Start,
+				SkipEndTime:        seg.End,
+				VideoTitle:         videoTitle,
+			})
+		}
+		if err := tx.Create(&songs).Error; err != nil {
+			return err
+		}
+		if favoriteID == "" {
+			return nil
+		}
+		ids := make([]string, 0, len(songs))
+		for _, song := range songs {
+			ids = append(ids, song.ID)
+		}
+		_, err := appendSongRefs(tx, favoriteID, ids)
+		return err
+	})
+	if err != nil {
+		_ = os.Remove(filepath.Join(dstDir, fileName))
+		return nil, err
+	}
+	return songs, nil
+}
+
+// ExportFavoriteCue renders a favorite whose songs are segments of one
+// video page (or one local file) as a cue sheet.
+func (s *Service) ExportFavoriteCue(favoriteID string) (string, error) {
+	var fav models.Favorite
+	if err := s.db.First(&fav, "id = ?", favoriteID).Error; err != nil {
+		return "", fmt.Errorf("未找到歌单: %w", err)
+	}
+	songs, err := s.getFavoriteSongs(favoriteID)
+	if err != nil {
+		return "", err
+	}
+	if len(songs) == 0 {
+		return "", fmt.Errorf("歌单为空")
+	}
+
+	first := songs[0]
+	for _, song := range songs[1:] {
+		if !sameRecording(first, song) {
+			return "", fmt.Errorf("歌单中的歌曲不属于同一个视频，无法导出 cue")
+		}
+	}
+	sort.SliceStable(songs, func(i, j int) bool { return songs[i].SkipStartTime < songs[j].SkipStartTime })
+
+	fileName := s.getLocalAudioFilename(first)
+	if first.BVID == "" {
+		if u, err := url.Parse(first.StreamURL); err == nil && u.Query().Get("f") != "" {
+			fileName = u.Query().Get("f")
+		}
+	}
+
+	var b strings.Builder
+	if first.Singer != "" {
+		fmt.Fprintf(&b, "PERFORMER %s\n", quoteCue(first.Singer))
+	}
+	title := first.VideoTitle
+	if title == "" {
+		title = fav.Title
+	}
+	fmt.Fprintf(&b, "TITLE %s\n", quoteCue(title))
+	if first.BVID != "" {
+		fmt.Fprintf(&b, "REM COMMENT \"https://www.bilibili.com/video/%s?p=%d\"\n", first.BVID, max(first.PageNumber, 1))
+	}
+	fmt.Fprintf(&b, "FILE %s WAVE\n", quoteCue(fileName))
+	for i, song := range songs {
+		fmt.Fprintf(&b, "  TRACK %02d AUDIO\n", i+1)
+		fmt.Fprintf(&b, "    TITLE %s\n", quoteCue(song.Name))
+		if song.Singer != "" {
+			fmt.Fprintf(&b, "    PERFORMER %s\n", quoteCue(song.Singer))
+		}
+		fmt.Fprintf(&b, "    INDEX 01 %s\n", formatCueTime(song.SkipStartTime))
+	}
+	return b.String(), nil
+}
+
+// sameRecording reports whether two songs are segments of the same audio.
+func sameRecording(a, b models.Song) bool {
+	if a.BVID != "" || b.BVID != "" {
+		return a.BVID == b.BVID && max(a.PageNumber, 1) == max(b.PageNumber, 1)
+	}
+	return a.SourceID != "" && a.SourceID == b.SourceID
+}
+
+// quoteCue quotes a cue value; cue has no escape syntax so inner quotes are replaced.
+func quoteCue(v string) string {
+	return `"` + strings.ReplaceAll(v, `"`, "'") + `"`
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
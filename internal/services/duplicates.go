@@ -0,0 +1,563 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"half-beat-player/internal/models"
+	"half-beat-player/internal/textmatch"
+
+	"gorm.io/gorm"
+)
+
+// Why songs were grouped as duplicates.
+const (
+	DuplicateReasonPage = "page" // 同一 BVID、同一分P、同一片段
+	DuplicateReasonName = "name" // 名称和歌手相似
+)
+
+const (
+	defaultDuplicateThreshold = 0.9
+	// duplicateWindowSlack is how far two skip windows may differ (seconds)
+	// and still count as the same segment.
+	duplicateWindowSlack = 5.0
+)
+
+// DuplicateSong is a song in a duplicate group with some context for review.
+type DuplicateSong struct {
+	Song        models.Song `json:"song"`
+	RefCount    int         `json:"refCount"`
+	FavoriteIDs []string    `json:"favoriteIds"`
+	HasLyric    bool        `json:"hasLyric"`
+}
+
+// DuplicateGroup is a set of songs that look like the same song.
+type DuplicateGroup struct {
+	Reason          string          `json:"reason"`
+	Key             string          `json:"key"`
+	Score           float64         `json:"score"` // 名称分组的最低相似度，页面分组为 1
+	Songs           []DuplicateSong `json:"songs"`
+	SuggestedKeepID string          `json:"suggestedKeepId"`
+}
+
+// sameSegment reports whether two songs of the same page cover the same
+// part of it. An end of 0 means "to the end of the page"; it is compared as
+// the page duration when either song knows it, and otherwise only matches
+// another open end.
+func sameSegment(a, b models.Song) bool {
+	if math.Abs(a.SkipStartTime-b.SkipStartTime) > duplicateWindowSlack {
+		return false
+	}
+	pageEnd := float64(max(a.Duration, b.Duration))
+	end := func(song models.Song) float64 {
+		if song.SkipEndTime > 0 {
+			return song.SkipEndTime
+		}
+		return pageEnd
+	}
+	endA, endB := end(a), end(b)
+	if endA == 0 || endB == 0 {
+		return endA == endB
+	}
+	return math.Abs(endA-endB) <= duplicateWindowSlack
+}
+
+// unionFind is a tiny disjoint-set over song indexes.
+type unionFind []int
+
+func newUnionFind(n int) unionFind {
+	uf := make(unionFind, n)
+	for i := range uf {
+		uf[i] = i
+	}
+	return uf
+}
+
+func (uf unionFind) find(i int) int {
+	for uf[i] != i {
+		uf[i] = uf[uf[i]]
+		i = uf[i]
+	}
+	return i
+}
+
+func (uf unionFind) union(a, b int) { uf[uf.find(a)] = uf.find(b) }
+
+// groups returns the sets with at least two members.
+func (uf unionFind) groups() [][]int {
+	byRoot := map[int][]int{}
+	for i := range uf {
+		r := uf.find(i)
+		byRoot[r] = append(byRoot[r], i)
+	}
+	out := make([][]int, 0)
+	for _, g := range byRoot {
+		if len(g) > 1 {
+			out = append(out, g)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i][0] < out[j][0] })
+	return out
+}
+
+// FindDuplicateSongs groups songs that are probably the same: the same
+// BVID/page/segment, or a fuzzy name+singer match at or above threshold
+// (0 uses the default of 0.9). A song may appear in both kinds of group.
+func (s *Service) FindDuplicateSongs(threshold float64) ([]DuplicateGroup, error) {
+	if threshold <= 0 || threshold > 1 {
+		threshold = defaultDuplicateThreshold
+	}
+
+	var songs []models.Song
+	if err := s.db.Order("created_at, id").Find(&songs).Error; err != nil {
+		return nil, err
+	}
+
+	var refs []models.SongRef
+	if err := s.db.Select("song_id", "favorite_id").Find(&refs).Error; err != nil {
+		return nil, err
+	}
+	favsBySong := map[string][]string{}
+	for _, r := range refs {
+		favsBySong[r.SongID] = append(favsBySong[r.SongID], r.FavoriteID)
+	}
+	var withLyric []string
+	if err := s.db.Model(&models.LyricMapping{}).Where("lyric <> ''").Pluck("id", &withLyric).Error; err != nil {
+		return nil, err
+	}
+	hasLyric := make(map[string]bool, len(withLyric))
+	for _, id := range withLyric {
+		hasLyric[id] = true
+	}
+	info := func(song models.Song) DuplicateSong {
+		favs := favsBySong[song.ID]
+		if favs == nil {
+			favs = []string{}
+		}
+		return DuplicateSong{
+			Song:        song,
+			RefCount:    len(favs),
+			FavoriteIDs: favs,
+			HasLyric:    hasLyric[song.ID] || song.Lyric != "",
+		}
+	}
+
+	var out []DuplicateGroup
+
+	// 1) 同一 BVID/分P/片段
+	pageUF := newUnionFind(len(songs))
+	byPage := map[string][]int{}
+	for i, song := range songs {
+		if song.BVID == "" {
+			continue
+		}
+		key := fmt.Sprintf("%s-P%d", song.BVID, max(song.PageNumber, 1))
+		for _, j := range byPage[key] {
+			if sameSegment(songs[i], songs[j]) {
+				pageUF.union(i, j)
+			}
+		}
+		byPage[key] = append(byPage[key], i)
+	}
+	for _, g := range pageUF.groups() {
+		group := DuplicateGroup{
+			Reason: DuplicateReasonPage,
+			Key:    fmt.Sprintf("%s-P%d", songs[g[0]].BVID, max(songs[g[0]].PageNumber, 1)),
+			Score:  1,
+		}
+		for _, i := range g {
+			group.Songs = append(group.Songs, info(songs[i]))
+		}
+		group.SuggestedKeepID = suggestKeep(group.Songs)
+		out = append(out, group)
+	}
+
+	// 2) 名称 + 歌手相似；按标题前两个字符分桶避免 O(n²)
+	nameUF := newUnionFind(len(songs))
+	titles := make([]string, len(songs))
+	buckets := map[string][]int{}
+	for i, song := range songs {
+		titles[i] = textmatch.CleanTitle(song.Name)
+		norm := []rune(textmatch.Normalize(titles[i]))
+		if len(norm) == 0 {
+			continue
+		}
+		key := string(norm[:min(2, len(norm))])
+		for _, j := range buckets[key] {
+			if pageUF.find(i) == pageUF.find(j) {
+				continue // 已在页面分组中
+			}
+			if textmatch.Similarity(titles[i], titles[j]) < threshold {
+				continue
+			}
+			if songs[i].Singer != "" && songs[j].Singer != "" &&
+				textmatch.Similarity(songs[i].Singer, songs[j].Singer) < 0.5 {
+				continue
+			}
+			nameUF.union(i, j)
+		}
+		buckets[key] = append(buckets[key], i)
+	}
+	for _, g := range nameUF.groups() {
+		group := DuplicateGroup{Reason: DuplicateReasonName, Key: titles[g[0]], Score: 1}
+		for a := 0; a < len(g); a++ {
+			group.Songs = append(group.Songs, info(songs[g[a]]))
+			for b := a + 1; b < len(g); b++ {
+				group.Score = math.Min(group.Score, textmatch.Similarity(titles[g[a]], titles[g[b]]))
+			}
+		}
+		group.SuggestedKeepID = suggestKeep(group.Songs)
+		out = append(out, group)
+	}
+
+	if out == nil {
+		out = []DuplicateGroup{}
+	}
+	return out, nil
+}
+
+// suggestKeep picks the song with the most references, then lyrics, then a
+// skip window, then the oldest.
+func suggestKeep(songs []DuplicateSong) string {
+	best := 0
+	rank := func(d DuplicateSong) [3]int {
+		r := [3]int{d.RefCount, 0, 0}
+		if d.HasLyric {
+			r[1] = 1
+		}
+		if d.Song.SkipStartTime > 0 || d.Song.SkipEndTime > 0 {
+			r[2] = 1
+		}
+		return r
+	}
+	for i := 1; i < len(songs); i++ {
+		a, b := rank(songs[i]), rank(songs[best])
+		for k := range a {
+			if a[k] != b[k] {
+				if a[k] > b[k] {
+					best = i
+				}
+				break
+			}
+		}
+	}
+	return songs[best].Song.ID
+}
+
+// mergeSongMetadata fills the survivor's empty fields from the others.
+func mergeSongMetadata(keep *models.Song, others []models.Song) {
+	fill := func(dst *string, src string) {
+		if strings.TrimSpace(*dst) == "" && src != "" {
+			*dst = src
+		}
+	}
+	for _, o := range others {
+		fill(&keep.Singer, o.Singer)
+		fill(&keep.SingerID, o.SingerID)
+		fill(&keep.Cover, o.Cover)
+		fill(&keep.CoverLocal, o.CoverLocal)
+		fill(&keep.PageTitle, o.PageTitle)
+		fill(&keep.VideoTitle, o.VideoTitle)
+		if keep.BVID == "" && o.BVID != "" {
+			keep.BVID, keep.PageNumber, keep.TotalPages = o.BVID, o.PageNumber, o.TotalPages
+		}
+		if keep.Lyric == "" && o.Lyric != "" {
+			keep.Lyric, keep.LyricOffset = o.Lyric, o.LyricOffset
+		}
+		if keep.SkipStartTime == 0 && keep.SkipEndTime == 0 && (o.SkipStartTime > 0 || o.SkipEndTime > 0) {
+			keep.SkipStartTime, keep.SkipEndTime = o.SkipStartTime, o.SkipEndTime
+		}
+		// 保留最晚过期的流地址
+		if o.StreamURL != "" && o.StreamURLExpiresAt.After(keep.StreamURLExpiresAt) {
+			keep.SourceID, keep.StreamURL, keep.StreamURLExpiresAt = o.SourceID, o.StreamURL, o.StreamURLExpiresAt
+		}
+	}
+}
+
+// MergeSongs merges songs into keepID: every favorite ref is re-pointed (a
+// favorite never ends up with the song twice), missing metadata, lyrics,
+// tags and skip window are taken from the merged songs, and the merged songs
+// plus orphaned stream sources are deleted.
+func (s *Service) MergeSongs(keepID string, mergeIDs []string) (models.Song, error) {
+	ids := make([]string, 0, len(mergeIDs))
+	for _, id := range mergeIDs {
+		if id != "" && id != keepID && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return models.Song{}, fmt.Errorf("没有需要合并的歌曲")
+	}
+
+	var keep models.Song
+	var merged []models.Song
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&keep, "id = ?", keepID).Error; err != nil {
+			return fmt.Errorf("未找到歌曲: %s", keepID)
+		}
+		if err := tx.Where("id IN ?", ids).Find(&merged).Error; err != nil {
+			return err
+		}
+		if len(merged) != len(ids) {
+			return fmt.Errorf("部分歌曲不存在")
+		}
+		// 按传入顺序处理，优先使用靠前歌曲的元数据
+		sort.SliceStable(merged, func(i, j int) bool {
+			return slices.Index(ids, merged[i].ID) < slices.Index(ids, merged[j].ID)
+		})
+
+		mergeSongMetadata(&keep, merged)
+		keep.UpdatedAt = time.Now()
+		if err := tx.Save(&keep).Error; err != nil {
+			return err
+		}
+		all := append([]string{keepID}, ids...)
+
+		// 歌单引用：每个歌单保留位置最靠前的一条
+		var refs []models.SongRef
+		if err := tx.Where("song_id IN ?", all).Order("favorite_id, position, id").Find(&refs).Error; err != nil {
+			return err
+		}
+		seen := map[string]bool{}
+		for _, r := range refs {
+			if seen[r.FavoriteID] {
+				if err := tx.Delete(&models.SongRef{}, r.ID).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			seen[r.FavoriteID] = true
+			if r.SongID != keepID {
+				if err := tx.Model(&models.SongRef{}).Where("id = ?", r.ID).Update("song_id", keepID).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := mergeLyrics(tx, keepID, ids); err != nil {
+			return err
+		}
+		if err := mergeSongTags(tx, keepID, ids); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.PlayHistory{}).Where("song_id IN ?", ids).Update("song_id", keepID).Error; err != nil {
+			return err
+		}
+		if err := remapPlaylistQueue(tx, keepID, ids); err != nil {
+			return err
+		}
+		if err := remapVolumeOffsets(tx, keepID, ids); err != nil {
+			return err
+		}
+
+		if err := tx.Where("id IN ?", ids).Delete(&models.Song{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("id NOT IN (SELECT DISTINCT source_id FROM songs WHERE source_id IS NOT NULL AND source_id != '')").
+			Delete(&models.StreamSource{}).Error
+	})
+	if err != nil {
+		return models.Song{}, err
+	}
+
+	s.mergeDownloadedFiles(keep, merged)
+	return keep, nil
+}
+
+// mergeLyrics keeps the survivor's lyric mapping (or adopts the first
+// non-empty one) and moves lyric tracks that do not clash.
+func mergeLyrics(tx *gorm.DB, keepID string, ids []string) error {
+	var keepMap models.LyricMapping
+	if err := tx.Where("id = ?", keepID).Limit(1).Find(&keepMap).Error; err != nil {
+		return err
+	}
+	if keepMap.Lyric == "" {
+		var others []models.LyricMapping
+		if err := tx.Where("id IN ? AND lyric <> ''", ids).Find(&others).Error; err != nil {
+			return err
+		}
+		for _, id := range ids {
+			for _, m := range others {
+				if m.ID != id {
+					continue
+				}
+				m.ID = keepID
+				m.UpdatedAt = time.Now()
+				if err := tx.Save(&m).Error; err != nil {
+					return err
+				}
+				keepMap = m
+				break
+			}
+			if keepMap.Lyric != "" {
+				break
+			}
+		}
+	}
+	if err := tx.Where("id IN ?", ids).Delete(&models.LyricMapping{}).Error; err != nil {
+		return err
+	}
+
+	var tracks []models.LyricTrack
+	if err := tx.Where("song_id IN ?", append([]string{keepID}, ids...)).Find(&tracks).Error; err != nil {
+		return err
+	}
+	have := map[string]bool{}
+	for _, t := range tracks {
+		if t.SongID == keepID {
+			have[t.Kind+"\x00"+t.Language] = true
+		}
+	}
+	for _, t := range tracks {
+		if t.SongID == keepID {
+			continue
+		}
+		key := t.Kind + "\x00" + t.Language
+		if have[key] {
+			if err := tx.Delete(&models.LyricTrack{}, "id = ?", t.ID).Error; err != nil {
+				return err
+			}
+			continue
+		}
+		have[key] = true
+		if err := tx.Model(&models.LyricTrack{}).Where("id = ?", t.ID).Update("song_id", keepID).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeSongTags gives the survivor the union of all tags.
+func mergeSongTags(tx *gorm.DB, keepID string, ids []string) error {
+	var tags []string
+	if err := tx.Model(&models.SongTag{}).Where("song_id IN ?", ids).Pluck("tag", &tags).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("song_id IN ?", ids).Delete(&models.SongTag{}).Error; err != nil {
+		return err
+	}
+	var existing []string
+	if err := tx.Model(&models.SongTag{}).Where("song_id = ?", keepID).Pluck("tag", &existing).Error; err != nil {
+		return err
+	}
+	rows := []models.SongTag{}
+	for _, t := range normaliseTags(append(existing, tags...)) {
+		if containsFold(existing, t) {
+			continue
+		}
+		rows = append(rows, models.SongTag{SongID: keepID, Tag: t})
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	return tx.Create(&rows).Error
+}
+
+// remapPlaylistQueue replaces merged ids in the saved playback queue. The
+// queue is a JSON array of song ids or of song objects with an "id".
+func remapPlaylistQueue(tx *gorm.DB, keepID string, ids []string) error {
+	var pl models.Playlist
+	if err := tx.Where("id = ?", 1).Limit(1).Find(&pl).Error; err != nil {
+		return err
+	}
+	if pl.Queue == "" {
+		return nil
+	}
+	var queue []any
+	if err := json.Unmarshal([]byte(pl.Queue), &queue); err != nil {
+		return nil // 无法识别的格式，保持原样
+	}
+	changed := false
+	for i, item := range queue {
+		switch v := item.(type) {
+		case string:
+			if slices.Contains(ids, v) {
+				queue[i] = keepID
+				changed = true
+			}
+		case map[string]any:
+			if id, ok := v["id"].(string); ok && slices.Contains(ids, id) {
+				v["id"] = keepID
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	data, err := json.Marshal(queue)
+	if err != nil {
+		return err
+	}
+	return tx.Model(&models.Playlist{}).Where("id = ?", 1).Update("queue", string(data)).Error
+}
+
+// remapVolumeOffsets moves per-song volume offsets to the survivor.
+func remapVolumeOffsets(tx *gorm.DB, keepID string, ids []string) error {
+	var setting models.PlayerSetting
+	if err := tx.Where("id = ?", 1).Limit(1).Find(&setting).Error; err != nil {
+		return err
+	}
+	offsets, ok := setting.Config["songVolumeOffsets"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	changed := false
+	for _, id := range ids {
+		v, ok := offsets[id]
+		if !ok {
+			continue
+		}
+		if _, has := offsets[keepID]; !has {
+			offsets[keepID] = v
+		}
+		delete(offsets, id)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	setting.UpdatedAt = time.Now()
+	return tx.Save(&setting).Error
+}
+
+// mergeDownloadedFiles keeps one downloaded file for the survivor. Best effort.
+func (s *Service) mergeDownloadedFiles(keep models.Song, merged []models.Song) {
+	dir := filepath.Join(s.dataDir, downloadsDir)
+	keepPath := filepath.Join(dir, s.getLocalAudioFilename(keep))
+	_, err := os.Stat(keepPath)
+	keepHas := err == nil
+	for _, m := range merged {
+		name := s.getLocalAudioFilename(m)
+		if name == "" || name == filepath.Base(keepPath) {
+			continue
+		}
+		p := filepath.Join(dir, name)
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+		if !keepHas {
+			if os.Rename(p, keepPath) == nil {
+				keepHas = true
+			}
+			continue
+		}
+		_ = os.Remove(p)
+	}
+}
+
+func containsFold(list []string, v string) bool {
+	for _, s := range list {
+		if strings.EqualFold(s, v) {
+			return true
+		}
+	}
+	return false
+}
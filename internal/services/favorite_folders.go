@@ -0,0 +1,308 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"half-beat-player/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// FavoriteSummary is a favorite without its refs, for the sidebar.
+type FavoriteSummary struct {
+	ID        string   `json:"id"`
+	Title     string   `json:"title"`
+	Kind      string   `json:"kind"`
+	FolderID  string   `json:"folderId"`
+	Position  int64    `json:"position"`
+	SongCount int      `json:"songCount"` // 智能歌单为 -1，需单独计算
+	Tags      []string `json:"tags"`
+}
+
+// FavoriteTreeNode is a folder with its sub folders and favorites.
+// The root node has an empty Folder.ID.
+type FavoriteTreeNode struct {
+	Folder    models.FavoriteFolder `json:"folder"`
+	Folders   []FavoriteTreeNode    `json:"folders"`
+	Favorites []FavoriteSummary     `json:"favorites"`
+}
+
+// ensureFolderExists checks a folder id; the empty id is the root.
+func ensureFolderExists(tx *gorm.DB, folderID string) error {
+	if folderID == "" {
+		return nil
+	}
+	var count int64
+	if err := tx.Model(&models.FavoriteFolder{}).Where("id = ?", folderID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("文件夹不存在: %s", folderID)
+	}
+	return nil
+}
+
+// ListFavoriteFolders returns all folders ordered by parent and position.
+func (s *Service) ListFavoriteFolders() ([]models.FavoriteFolder, error) {
+	folders := []models.FavoriteFolder{}
+	if err := s.db.Order("parent_id, position, created_at").Find(&folders).Error; err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+// CreateFavoriteFolder creates a folder at the end of parentID ("" for root).
+func (s *Service) CreateFavoriteFolder(name string, parentID string) (models.FavoriteFolder, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return models.FavoriteFolder{}, fmt.Errorf("文件夹名称不能为空")
+	}
+	folder := models.FavoriteFolder{ID: "Folder-" + uuid.NewString(), Name: name, ParentID: parentID}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureFolderExists(tx, parentID); err != nil {
+			return err
+		}
+		var last int64
+		if err := tx.Model(&models.FavoriteFolder{}).Where("parent_id = ?", parentID).
+			Select("COALESCE(MAX(position), 0)").Scan(&last).Error; err != nil {
+			return err
+		}
+		folder.Position = last + 1
+		return tx.Create(&folder).Error
+	})
+	return folder, err
+}
+
+// UpdateFavoriteFolder renames a folder and stores its collapsed state.
+func (s *Service) UpdateFavoriteFolder(id string, name string, collapsed bool) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("文件夹名称不能为空")
+	}
+	res := s.db.Model(&models.FavoriteFolder{}).Where("id = ?", id).
+		Updates(map[string]any{"name": name, "collapsed": collapsed, "updated_at": time.Now()})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("文件夹不存在: %s", id)
+	}
+	return nil
+}
+
+// MoveFavoriteFolder moves a folder under parentID at toIndex among its new siblings.
+func (s *Service) MoveFavoriteFolder(id string, parentID string, toIndex int) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureFolderExists(tx, id); err != nil {
+			return err
+		}
+		if err := ensureFolderExists(tx, parentID); err != nil {
+			return err
+		}
+		// 不能移动到自身或自己的子文件夹中
+		for cur := parentID; cur != ""; {
+			if cur == id {
+				return fmt.Errorf("不能把文件夹移动到自身或其子文件夹中")
+			}
+			var f models.FavoriteFolder
+			if err := tx.Select("parent_id").First(&f, "id = ?", cur).Error; err != nil {
+				return err
+			}
+			cur = f.ParentID
+		}
+
+		var siblings []models.FavoriteFolder
+		if err := tx.Where("parent_id = ? AND id <> ?", parentID, id).
+			Order("position, created_at").Find(&siblings).Error; err != nil {
+			return err
+		}
+		toIndex = max(0, min(toIndex, len(siblings)))
+		ids := make([]string, 0, len(siblings)+1)
+		for i, f := range siblings {
+			if i == toIndex {
+				ids = append(ids, id)
+			}
+			ids = append(ids, f.ID)
+		}
+		if toIndex == len(siblings) {
+			ids = append(ids, id)
+		}
+		for i, fid := range ids {
+			updates := map[string]any{"position": int64(i + 1)}
+			if fid == id {
+				updates["parent_id"] = parentID
+				updates["updated_at"] = time.Now()
+			}
+			if err := tx.Model(&models.FavoriteFolder{}).Where("id = ?", fid).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DeleteFavoriteFolder removes a folder; its sub folders and favorites move
+// to the folder's parent, nothing else is deleted.
+func (s *Service) DeleteFavoriteFolder(id string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var folder models.FavoriteFolder
+		if err := tx.First(&folder, "id = ?", id).Error; err != nil {
+			return fmt.Errorf("文件夹不存在: %s", id)
+		}
+		if err := tx.Model(&models.FavoriteFolder{}).Where("parent_id = ?", id).
+			Update("parent_id", folder.ParentID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Favorite{}).Where("folder_id = ?", id).
+			Update("folder_id", folder.ParentID).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.FavoriteFolder{}, "id = ?", id).Error
+	})
+}
+
+// MoveFavoriteToFolder moves a favorite into folderID ("" for root) at toIndex.
+func (s *Service) MoveFavoriteToFolder(favoriteID string, folderID string, toIndex int) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureFolderExists(tx, folderID); err != nil {
+			return err
+		}
+		var count int64
+		if err := tx.Model(&models.Favorite{}).Where("id = ?", favoriteID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("歌单不存在: %s", favoriteID)
+		}
+
+		var siblings []string
+		if err := tx.Model(&models.Favorite{}).Where("folder_id = ? AND id <> ?", folderID, favoriteID).
+			Order("position, created_at").Pluck("id", &siblings).Error; err != nil {
+			return err
+		}
+		toIndex = max(0, min(toIndex, len(siblings)))
+		ids := make([]string, 0, len(siblings)+1)
+		ids = append(ids, siblings[:toIndex]...)
+		ids = append(ids, favoriteID)
+		ids = append(ids, siblings[toIndex:]...)
+		for i, fid := range ids {
+			updates := map[string]any{"position": int64(i + 1)}
+			if fid == favoriteID {
+				updates["folder_id"] = folderID
+			}
+			// 只调整顺序，不更新 updated_at
+			if err := tx.Model(&models.Favorite{}).Where("id = ?", fid).UpdateColumns(updates).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// listFavoriteSummaries returns summaries of the favorites matching the scope.
+func (s *Service) listFavoriteSummaries(scope func(*gorm.DB) *gorm.DB) ([]FavoriteSummary, error) {
+	var favs []models.Favorite
+	q := s.db.Model(&models.Favorite{})
+	if scope != nil {
+		q = scope(q)
+	}
+	if err := q.Order("position, created_at").Find(&favs).Error; err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		FavoriteID string
+		N          int
+	}
+	if err := s.db.Model(&models.SongRef{}).Select("favorite_id, COUNT(*) AS n").
+		Group("favorite_id").Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	countByID := make(map[string]int, len(counts))
+	for _, c := range counts {
+		countByID[c.FavoriteID] = c.N
+	}
+	tags, err := s.favoriteTagMap()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]FavoriteSummary, 0, len(favs))
+	for _, f := range favs {
+		sum := FavoriteSummary{
+			ID:        f.ID,
+			Title:     f.Title,
+			Kind:      f.Kind,
+			FolderID:  f.FolderID,
+			Position:  f.Position,
+			SongCount: countByID[f.ID],
+			Tags:      tags[f.ID],
+		}
+		if f.Kind == models.FavoriteKindSmart {
+			sum.SongCount = -1
+		}
+		if sum.Tags == nil {
+			sum.Tags = []string{}
+		}
+		out = append(out, sum)
+	}
+	return out, nil
+}
+
+// GetFavoriteTree returns folders and favorite summaries as a tree. Favorites
+// in a folder that no longer exists are shown at the root.
+func (s *Service) GetFavoriteTree() (FavoriteTreeNode, error) {
+	folders, err := s.ListFavoriteFolders()
+	if err != nil {
+		return FavoriteTreeNode{}, err
+	}
+	favs, err := s.listFavoriteSummaries(nil)
+	if err != nil {
+		return FavoriteTreeNode{}, err
+	}
+
+	known := make(map[string]bool, len(folders))
+	for _, f := range folders {
+		known[f.ID] = true
+	}
+	childFolders := map[string][]models.FavoriteFolder{}
+	for _, f := range folders {
+		parent := f.ParentID
+		if !known[parent] || parent == f.ID {
+			parent = ""
+		}
+		childFolders[parent] = append(childFolders[parent], f)
+	}
+	childFavs := map[string][]FavoriteSummary{}
+	for _, f := range favs {
+		folder := f.FolderID
+		if !known[folder] {
+			folder = ""
+		}
+		childFavs[folder] = append(childFavs[folder], f)
+	}
+
+	visited := map[string]bool{}
+	var build func(folder models.FavoriteFolder) FavoriteTreeNode
+	build = func(folder models.FavoriteFolder) FavoriteTreeNode {
+		visited[folder.ID] = true
+		node := FavoriteTreeNode{Folder: folder, Folders: []FavoriteTreeNode{}, Favorites: childFavs[folder.ID]}
+		if node.Favorites == nil {
+			node.Favorites = []FavoriteSummary{}
+		}
+		subs := childFolders[folder.ID]
+		sort.SliceStable(subs, func(i, j int) bool { return subs[i].Position < subs[j].Position })
+		for _, sub := range subs {
+			if visited[sub.ID] {
+				continue
+			}
+			node.Folders = append(node.Folders, build(sub))
+		}
+		return node
+	}
+	return build(models.FavoriteFolder{}), nil
+}
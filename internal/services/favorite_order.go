@@ -0,0 +1,365 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"half-beat-player/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// songRefPositionGap is the distance between neighbouring refs after a
+// renumber, leaving room for many moves before the next renumber.
+const songRefPositionGap int64 = 1024
+
+// Sort keys accepted by SortFavorite.
+const (
+	FavoriteSortAddedAt  = "addedAt"
+	FavoriteSortName     = "name"
+	FavoriteSortSinger   = "singer"
+	FavoriteSortDuration = "duration"
+)
+
+// orderedSongRefs returns the refs of a favorite in playback order.
+func orderedSongRefs(tx *gorm.DB, favoriteID string) ([]models.SongRef, error) {
+	refs := []models.SongRef{}
+	if err := tx.Where("favorite_id = ?", favoriteID).Order("position, id").Find(&refs).Error; err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// orderSongRefs is the Preload callback that keeps Favorite.SongIDs in order.
+func orderSongRefs(db *gorm.DB) *gorm.DB {
+	return db.Order("position, id")
+}
+
+// appendSongRefs adds songs to the end of a favorite.
+func appendSongRefs(tx *gorm.DB, favoriteID string, songIDs []string) ([]models.SongRef, error) {
+	if len(songIDs) == 0 {
+		return []models.SongRef{}, nil
+	}
+	var last int64
+	if err := tx.Model(&models.SongRef{}).Where("favorite_id = ?", favoriteID).
+		Select("COALESCE(MAX(position), 0)").Scan(&last).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	refs := make([]models.SongRef, 0, len(songIDs))
+	for i, id := range songIDs {
+		refs = append(refs, models.SongRef{
+			FavoriteID: favoriteID,
+			SongID:     id,
+			Position:   last + int64(i+1)*songRefPositionGap,
+			AddedAt:    now,
+		})
+	}
+	if err := tx.Create(&refs).Error; err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// setSongRefPosition updates a single ref's position.
+func setSongRefPosition(tx *gorm.DB, id uint, pos int64) error {
+	return tx.Model(&models.SongRef{}).Where("id = ?", id).Update("position", pos).Error
+}
+
+// renumberSongRefs spreads refs evenly in the given order, touching only
+// rows whose position actually changes.
+func renumberSongRefs(tx *gorm.DB, refs []models.SongRef) error {
+	for i := range refs {
+		pos := int64(i+1) * songRefPositionGap
+		if refs[i].Position == pos {
+			continue
+		}
+		if err := setSongRefPosition(tx, refs[i].ID, pos); err != nil {
+			return err
+		}
+		refs[i].Position = pos
+	}
+	return nil
+}
+
+// applySongRefOrder rewrites positions so refs follow target. Refs on the
+// longest run already in increasing order keep their position; only the
+// others are moved into the gaps, falling back to a renumber when a gap is full.
+func applySongRefOrder(tx *gorm.DB, target []models.SongRef) error {
+	n := len(target)
+	if n == 0 {
+		return nil
+	}
+
+	// 最长严格递增子序列（按当前 position），这些行不需要改写
+	keep := make([]bool, n)
+	tails := []int{}
+	prev := make([]int, n)
+	for i := range target {
+		p := target[i].Position
+		j := sort.Search(len(tails), func(k int) bool { return target[tails[k]].Position >= p })
+		if j > 0 {
+			prev[i] = tails[j-1]
+		} else {
+			prev[i] = -1
+		}
+		if j == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[j] = i
+		}
+	}
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+			keep[i] = true
+		}
+	}
+
+	positions := make([]int64, n)
+	for i := 0; i < n; {
+		if keep[i] {
+			positions[i] = target[i].Position
+			i++
+			continue
+		}
+		start := i
+		for i < n && !keep[i] {
+			i++
+		}
+		var lo int64
+		if start > 0 {
+			lo = positions[start-1]
+		}
+		count := int64(i - start)
+		if i == n {
+			for k := int64(0); k < count; k++ {
+				positions[start+int(k)] = lo + (k+1)*songRefPositionGap
+			}
+			continue
+		}
+		hi := target[i].Position
+		if hi-lo <= count {
+			// 间隙不足，整体重新编号
+			return renumberSongRefs(tx, target)
+		}
+		for k := int64(0); k < count; k++ {
+			positions[start+int(k)] = lo + (hi-lo)*(k+1)/(count+1)
+		}
+	}
+
+	for i := range target {
+		if positions[i] == target[i].Position {
+			continue
+		}
+		if err := setSongRefPosition(tx, target[i].ID, positions[i]); err != nil {
+			return err
+		}
+		target[i].Position = positions[i]
+	}
+	return nil
+}
+
+// MoveSongRef moves one ref of a favorite to toIndex (0-based, in the order
+// after removal). Usually only the moved row is written.
+func (s *Service) MoveSongRef(favoriteID string, refID uint, toIndex int) ([]models.SongRef, error) {
+	var out []models.SongRef
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureEditableFavorite(tx, favoriteID); err != nil {
+			return err
+		}
+		refs, err := orderedSongRefs(tx, favoriteID)
+		if err != nil {
+			return err
+		}
+		from := -1
+		for i, r := range refs {
+			if r.ID == refID {
+				from = i
+				break
+			}
+		}
+		if from < 0 {
+			return fmt.Errorf("歌单中不存在该歌曲引用: %d", refID)
+		}
+
+		moved := refs[from]
+		rest := append(refs[:from:from], refs[from+1:]...)
+		toIndex = max(0, min(toIndex, len(rest)))
+
+		var lo, hi int64
+		if toIndex > 0 {
+			lo = rest[toIndex-1].Position
+		}
+		if toIndex < len(rest) {
+			hi = rest[toIndex].Position
+		} else {
+			hi = lo + 2*songRefPositionGap
+		}
+
+		if hi-lo > 1 {
+			if err := setSongRefPosition(tx, moved.ID, lo+(hi-lo)/2); err != nil {
+				return err
+			}
+		} else {
+			reordered := make([]models.SongRef, 0, len(refs))
+			reordered = append(reordered, rest[:toIndex]...)
+			reordered = append(reordered, moved)
+			reordered = append(reordered, rest[toIndex:]...)
+			if err := renumberSongRefs(tx, reordered); err != nil {
+				return err
+			}
+		}
+		if err := touchFavorite(tx, favoriteID); err != nil {
+			return err
+		}
+		out, err = orderedSongRefs(tx, favoriteID)
+		return err
+	})
+	return out, err
+}
+
+// ReorderFavorite applies a full new order given as ref ids. The ids must be
+// exactly the favorite's current refs.
+func (s *Service) ReorderFavorite(favoriteID string, refIDs []uint) ([]models.SongRef, error) {
+	var out []models.SongRef
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureEditableFavorite(tx, favoriteID); err != nil {
+			return err
+		}
+		refs, err := orderedSongRefs(tx, favoriteID)
+		if err != nil {
+			return err
+		}
+		if len(refIDs) != len(refs) {
+			return fmt.Errorf("排序列表与歌单内容不一致")
+		}
+		byID := make(map[uint]models.SongRef, len(refs))
+		for _, r := range refs {
+			byID[r.ID] = r
+		}
+		target := make([]models.SongRef, 0, len(refIDs))
+		for _, id := range refIDs {
+			r, ok := byID[id]
+			if !ok {
+				return fmt.Errorf("排序列表与歌单内容不一致")
+			}
+			delete(byID, id)
+			target = append(target, r)
+		}
+
+		if err := applySongRefOrder(tx, target); err != nil {
+			return err
+		}
+		if err := touchFavorite(tx, favoriteID); err != nil {
+			return err
+		}
+		out, err = orderedSongRefs(tx, favoriteID)
+		return err
+	})
+	return out, err
+}
+
+// songDurationSeconds is the playable span of a song in seconds: from the
+// skip start to the skip end, or to the end of the page when no end is set.
+// It returns 0 when the length is unknown.
+func songDurationSeconds(song models.Song) float64 {
+	end := float64(song.Duration)
+	if song.SkipEndTime > 0 && (end == 0 || song.SkipEndTime < end) {
+		end = song.SkipEndTime
+	}
+	if end <= song.SkipStartTime {
+		return 0
+	}
+	return end - song.SkipStartTime
+}
+
+// SortFavorite sorts a favorite by added date, name, singer or duration.
+// Songs with unknown duration are placed last.
+func (s *Service) SortFavorite(favoriteID string, by string, desc bool) ([]models.SongRef, error) {
+	var out []models.SongRef
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureEditableFavorite(tx, favoriteID); err != nil {
+			return err
+		}
+		refs, err := orderedSongRefs(tx, favoriteID)
+		if err != nil {
+			return err
+		}
+		ids := make([]string, 0, len(refs))
+		for _, r := range refs {
+			ids = append(ids, r.SongID)
+		}
+		var songs []models.Song
+		if len(ids) > 0 {
+			if err := tx.Where("id IN ?", ids).Find(&songs).Error; err != nil {
+				return err
+			}
+		}
+		songByID := make(map[string]models.Song, len(songs))
+		for _, song := range songs {
+			songByID[song.ID] = song
+		}
+
+		var less func(a, b models.SongRef) int
+		switch by {
+		case FavoriteSortAddedAt:
+			less = func(a, b models.SongRef) int { return a.AddedAt.Compare(b.AddedAt) }
+		case FavoriteSortName:
+			less = func(a, b models.SongRef) int {
+				return strings.Compare(strings.ToLower(songByID[a.SongID].Name), strings.ToLower(songByID[b.SongID].Name))
+			}
+		case FavoriteSortSinger:
+			less = func(a, b models.SongRef) int {
+				return strings.Compare(strings.ToLower(songByID[a.SongID].Singer), strings.ToLower(songByID[b.SongID].Singer))
+			}
+		case FavoriteSortDuration:
+			less = func(a, b models.SongRef) int {
+				da, db := songDurationSeconds(songByID[a.SongID]), songDurationSeconds(songByID[b.SongID])
+				switch {
+				case da <= 0 || db <= 0:
+					// 未知时长始终排在最后，不受 desc 影响
+					return 0
+				case da < db:
+					return -1
+				case da > db:
+					return 1
+				}
+				return 0
+			}
+		default:
+			return fmt.Errorf("不支持的排序方式: %s", by)
+		}
+
+		sort.SliceStable(refs, func(i, j int) bool {
+			if by == FavoriteSortDuration {
+				ui, uj := songDurationSeconds(songByID[refs[i].SongID]) <= 0, songDurationSeconds(songByID[refs[j].SongID]) <= 0
+				if ui != uj {
+					return uj
+				}
+			}
+			c := less(refs[i], refs[j])
+			if desc {
+				return c > 0
+			}
+			return c < 0
+		})
+
+		if err := applySongRefOrder(tx, refs); err != nil {
+			return err
+		}
+		if err := touchFavorite(tx, favoriteID); err != nil {
+			return err
+		}
+		out, err = orderedSongRefs(tx, favoriteID)
+		return err
+	})
+	return out, err
+}
+
+// touchFavorite bumps a favorite's updated_at after its refs changed.
+func touchFavorite(tx *gorm.DB, favoriteID string) error {
+	return tx.Model(&models.Favorite{}).Where("id = ?", favoriteID).Update("updated_at", time.Now()).Error
+}
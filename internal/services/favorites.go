@@ -1,6 +1,9 @@
 package services
 
 import (
+	"fmt"
+	"time"
+
 	"half-beat-player/internal/models"
 
 	"github.com/google/uuid"
@@ -9,16 +12,35 @@ import (
 )
 
 // ListFavorites returns favorites with song ids only (frontend can hydrate).
+// Smart favorites are evaluated and returned with synthetic refs.
 func (s *Service) ListFavorites() ([]models.Favorite, error) {
 	var favs []models.Favorite
-	if err := s.db.Preload("SongIDs").Find(&favs).Error; err != nil {
+	if err := s.db.Preload("SongIDs", orderSongRefs).Find(&favs).Error; err != nil {
 		return nil, err
 	}
+	for i := range favs {
+		if favs[i].Kind != models.FavoriteKindSmart {
+			continue
+		}
+		refs, err := s.smartSongRefs(s.db, favs[i])
+		if err != nil {
+			// 规则失效时返回空列表，不影响其他歌单
+			refs = []models.SongRef{}
+		}
+		favs[i].SongIDs = refs
+	}
 	return favs, nil
 }
 
-// SaveFavorite stores a favorite list.
+// SaveFavorite stores a favorite list. Refs are rewritten in the given
+// order; added-at times of songs already in the list are kept.
+// For small edits prefer AddSongsToFavorite and friends, which do not
+// rewrite the whole list.
 func (s *Service) SaveFavorite(fav models.Favorite) error {
+	if fav.Kind == models.FavoriteKindSmart {
+		_, err := s.SaveSmartFavorite(fav)
+		return err
+	}
 	if fav.ID == "" {
 		fav.ID = "FavList-" + uuid.NewString()
 	}
@@ -26,11 +48,35 @@ func (s *Service) SaveFavorite(fav models.Favorite) error {
 		if err := tx.Clauses(clauseOnConflictID()).Create(&fav).Error; err != nil {
 			return err
 		}
+		var stored models.Favorite
+		if err := tx.Select("kind").First(&stored, "id = ?", fav.ID).Error; err != nil {
+			return err
+		}
+		if stored.Kind == models.FavoriteKindSmart {
+			// 智能歌单只更新标题，内容由规则决定
+			return nil
+		}
+		var old []models.SongRef
+		if err := tx.Where("favorite_id = ?", fav.ID).Find(&old).Error; err != nil {
+			return err
+		}
+		addedAt := make(map[string]time.Time, len(old))
+		for _, r := range old {
+			addedAt[r.SongID] = r.AddedAt
+		}
 		if err := tx.Where("favorite_id = ?", fav.ID).Delete(&models.SongRef{}).Error; err != nil {
 			return err
 		}
+		now := time.Now()
 		for i := range fav.SongIDs {
+			fav.SongIDs[i].ID = 0
 			fav.SongIDs[i].FavoriteID = fav.ID
+			fav.SongIDs[i].Position = int64(i+1) * songRefPositionGap
+			if t, ok := addedAt[fav.SongIDs[i].SongID]; ok && !t.IsZero() {
+				fav.SongIDs[i].AddedAt = t
+			} else if fav.SongIDs[i].AddedAt.IsZero() {
+				fav.SongIDs[i].AddedAt = now
+			}
 		}
 		if len(fav.SongIDs) == 0 {
 			return nil
@@ -39,12 +85,18 @@ func (s *Service) SaveFavorite(fav models.Favorite) error {
 	})
 }
 
-// DeleteFavorite deletes a favorite and its song refs.
+// DeleteFavorite deletes a favorite, its song refs and its tags.
 func (s *Service) DeleteFavorite(id string) error {
 	return s.db.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Delete(&models.Favorite{}, "id = ?", id).Error; err != nil {
 			return err
 		}
+		if err := tx.Delete(&models.FavoriteTag{}, "favorite_id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.BiliFavoriteLink{}, "favorite_id = ?", id).Error; err != nil {
+			return err
+		}
 		return tx.Delete(&models.SongRef{}, "favorite_id = ?", id).Error
 	})
 }
@@ -56,3 +108,216 @@ func clauseOnConflictID() clause.Expression {
 		DoUpdates: clause.Assignments(map[string]interface{}{"title": clause.Expr{SQL: "excluded.title"}, "updated_at": clause.Expr{SQL: "excluded.updated_at"}}),
 	}
 }
+
+// FavoriteAddOptions controls how songs are added to a favorite.
+type FavoriteAddOptions struct {
+	// AllowDuplicates adds songs even if the favorite already contains them.
+	AllowDuplicates bool `json:"allowDuplicates"`
+	// InsertBefore is the ref id to insert in front of; 0 appends to the end.
+	InsertBefore uint `json:"insertBefore"`
+}
+
+// FavoriteMoveResult holds both favorites' refs after a move.
+type FavoriteMoveResult struct {
+	From []models.SongRef `json:"from"`
+	To   []models.SongRef `json:"to"`
+}
+
+// ensureEditableFavorite returns an error if the favorite does not exist or
+// is a smart favorite, whose songs cannot be edited directly.
+func ensureEditableFavorite(tx *gorm.DB, favoriteID string) error {
+	var fav models.Favorite
+	if err := tx.Select("id", "kind").Where("id = ?", favoriteID).Limit(1).Find(&fav).Error; err != nil {
+		return err
+	}
+	if fav.ID == "" {
+		return fmt.Errorf("歌单不存在: %s", favoriteID)
+	}
+	if fav.Kind == models.FavoriteKindSmart {
+		return fmt.Errorf("智能歌单的内容由规则生成，不能直接修改")
+	}
+	return nil
+}
+
+// addSongsToFavorite inserts song refs inside an open transaction.
+func addSongsToFavorite(tx *gorm.DB, favoriteID string, songIDs []string, opts FavoriteAddOptions) error {
+	if err := ensureEditableFavorite(tx, favoriteID); err != nil {
+		return err
+	}
+
+	var found int64
+	unique := make(map[string]bool, len(songIDs))
+	for _, id := range songIDs {
+		unique[id] = true
+	}
+	ids := make([]string, 0, len(unique))
+	for id := range unique {
+		ids = append(ids, id)
+	}
+	if len(ids) > 0 {
+		if err := tx.Model(&models.Song{}).Where("id IN ?", ids).Count(&found).Error; err != nil {
+			return err
+		}
+	}
+	if int(found) != len(ids) {
+		return fmt.Errorf("部分歌曲不存在")
+	}
+
+	refs, err := orderedSongRefs(tx, favoriteID)
+	if err != nil {
+		return err
+	}
+	toAdd := songIDs
+	if !opts.AllowDuplicates {
+		present := make(map[string]bool, len(refs)+len(songIDs))
+		for _, r := range refs {
+			present[r.SongID] = true
+		}
+		toAdd = make([]string, 0, len(songIDs))
+		for _, id := range songIDs {
+			if present[id] {
+				continue
+			}
+			present[id] = true
+			toAdd = append(toAdd, id)
+		}
+	}
+	if len(toAdd) == 0 {
+		return nil
+	}
+
+	if opts.InsertBefore == 0 {
+		_, err := appendSongRefs(tx, favoriteID, toAdd)
+		return err
+	}
+	at := -1
+	for i, r := range refs {
+		if r.ID == opts.InsertBefore {
+			at = i
+			break
+		}
+	}
+	if at < 0 {
+		return fmt.Errorf("歌单中不存在该歌曲引用: %d", opts.InsertBefore)
+	}
+
+	var lo int64
+	if at > 0 {
+		lo = refs[at-1].Position
+	}
+	hi, count := refs[at].Position, int64(len(toAdd))
+	now := time.Now()
+	added := make([]models.SongRef, 0, len(toAdd))
+	for i, id := range toAdd {
+		added = append(added, models.SongRef{
+			FavoriteID: favoriteID,
+			SongID:     id,
+			Position:   lo + (hi-lo)*int64(i+1)/(count+1),
+			AddedAt:    now,
+		})
+	}
+	if err := tx.Create(&added).Error; err != nil {
+		return err
+	}
+	if hi-lo > count {
+		return nil
+	}
+	// 间隙不足，整体重新编号
+	target := make([]models.SongRef, 0, len(refs)+len(added))
+	target = append(target, refs[:at]...)
+	target = append(target, added...)
+	target = append(target, refs[at:]...)
+	return renumberSongRefs(tx, target)
+}
+
+// AddSongsToFavorite adds songs to a favorite in one transaction and returns
+// the updated refs. Songs already in the favorite are skipped unless
+// opts.AllowDuplicates is set.
+func (s *Service) AddSongsToFavorite(favoriteID string, songIDs []string, opts FavoriteAddOptions) ([]models.SongRef, error) {
+	var out []models.SongRef
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := addSongsToFavorite(tx, favoriteID, songIDs, opts); err != nil {
+			return err
+		}
+		if err := touchFavorite(tx, favoriteID); err != nil {
+			return err
+		}
+		var err error
+		out, err = orderedSongRefs(tx, favoriteID)
+		return err
+	})
+	return out, err
+}
+
+// RemoveSongsFromFavorite removes every ref of the given songs from a
+// favorite and returns the remaining refs. The songs themselves are kept.
+func (s *Service) RemoveSongsFromFavorite(favoriteID string, songIDs []string) ([]models.SongRef, error) {
+	var out []models.SongRef
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureEditableFavorite(tx, favoriteID); err != nil {
+			return err
+		}
+		if len(songIDs) > 0 {
+			if err := tx.Where("favorite_id = ? AND song_id IN ?", favoriteID, songIDs).Delete(&models.SongRef{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := touchFavorite(tx, favoriteID); err != nil {
+			return err
+		}
+		var err error
+		out, err = orderedSongRefs(tx, favoriteID)
+		return err
+	})
+	return out, err
+}
+
+// MoveSongsBetweenFavorites removes songs from one favorite and adds them to
+// another in a single transaction. With duplicate protection, songs already in
+// the target are only removed from the source.
+func (s *Service) MoveSongsBetweenFavorites(fromID string, toID string, songIDs []string, opts FavoriteAddOptions) (FavoriteMoveResult, error) {
+	var out FavoriteMoveResult
+	if fromID == toID {
+		return out, fmt.Errorf("源歌单与目标歌单相同")
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := ensureEditableFavorite(tx, fromID); err != nil {
+			return err
+		}
+		// 只移动源歌单中确实存在的歌曲，保持源歌单中的顺序
+		var refs []models.SongRef
+		if len(songIDs) > 0 {
+			if err := tx.Where("favorite_id = ? AND song_id IN ?", fromID, songIDs).
+				Order("position, id").Find(&refs).Error; err != nil {
+				return err
+			}
+		}
+		moving := make([]string, 0, len(refs))
+		for _, r := range refs {
+			moving = append(moving, r.SongID)
+		}
+
+		if err := addSongsToFavorite(tx, toID, moving, opts); err != nil {
+			return err
+		}
+		if len(refs) > 0 {
+			if err := tx.Where("favorite_id = ? AND song_id IN ?", fromID, songIDs).Delete(&models.SongRef{}).Error; err != nil {
+				return err
+			}
+		}
+		if err := touchFavorite(tx, fromID); err != nil {
+			return err
+		}
+		if err := touchFavorite(tx, toID); err != nil {
+			return err
+		}
+
+		var err error
+		if out.From, err = orderedSongRefs(tx, fromID); err != nil {
+			return err
+		}
+		out.To, err = orderedSongRefs(tx, toID)
+		return err
+	})
+	return out, err
+}
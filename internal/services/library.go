@@ -13,6 +13,14 @@ type ExportData struct {
 	Favorites []models.Favorite     `json:"favorites"`
 	Settings  models.PlayerSetting  `json:"settings"`
 	Lyrics    []models.LyricMapping `json:"lyrics"`
+	// LyricTracks 为翻译/罗马音等附加歌词，旧备份中没有此字段
+	LyricTracks []models.LyricTrack `json:"lyricTracks,omitempty"`
+	// 歌单文件夹与标签，旧备份中没有这些字段
+	Folders      []models.FavoriteFolder `json:"folders,omitempty"`
+	FavoriteTags []models.FavoriteTag    `json:"favoriteTags,omitempty"`
+	SongTags     []models.SongTag        `json:"songTags,omitempty"`
+	// 与 B 站收藏夹的关联，旧备份中没有此字段
+	BiliLinks []models.BiliFavoriteLink `json:"biliLinks,omitempty"`
 }
 
 func (s *Service) ExportData() (ExportData, error) {
@@ -20,61 +28,139 @@ func (s *Service) ExportData() (ExportData, error) {
 	if err := s.db.Find(&out.Songs).Error; err != nil {
 		return out, err
 	}
-	if err := s.db.Preload("SongIDs").Find(&out.Favorites).Error; err != nil {
+	if err := s.db.Preload("SongIDs", orderSongRefs).Find(&out.Favorites).Error; err != nil {
 		return out, err
 	}
 	out.Settings, _ = s.GetPlayerSetting()
 	if err := s.db.Find(&out.Lyrics).Error; err != nil {
 		return out, err
 	}
+	if err := s.db.Find(&out.LyricTracks).Error; err != nil {
+		return out, err
+	}
+	if err := s.db.Find(&out.Folders).Error; err != nil {
+		return out, err
+	}
+	if err := s.db.Find(&out.FavoriteTags).Error; err != nil {
+		return out, err
+	}
+	if err := s.db.Find(&out.SongTags).Error; err != nil {
+		return out, err
+	}
+	if err := s.db.Find(&out.BiliLinks).Error; err != nil {
+		return out, err
+	}
 	return out, nil
 }
 
+// ImportData replaces the library with in.
 func (s *Service) ImportData(in ExportData) error {
+	if err := s.snapshotBefore(SnapshotBeforeImport); err != nil {
+		return err
+	}
 	return s.db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Exec("DELETE FROM song_refs").Error; err != nil {
+		return importDataTx(tx, in)
+	})
+}
+
+// importDataTx deletes the library and inserts in, inside tx.
+func importDataTx(tx *gorm.DB, in ExportData) error {
+	if err := tx.Exec("DELETE FROM song_refs").Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("DELETE FROM favorites").Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("DELETE FROM songs").Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("DELETE FROM lyric_mappings").Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("DELETE FROM lyric_tracks").Error; err != nil {
+		return err
+	}
+	for _, table := range []string{"favorite_folders", "favorite_tags", "song_tags", "bili_favorite_links"} {
+		if err := tx.Exec("DELETE FROM " + table).Error; err != nil {
 			return err
 		}
-		if err := tx.Exec("DELETE FROM favorites").Error; err != nil {
+	}
+	if len(in.Songs) > 0 {
+		if err := tx.Save(&in.Songs).Error; err != nil {
 			return err
 		}
-		if err := tx.Exec("DELETE FROM songs").Error; err != nil {
+	}
+	for i := range in.Favorites {
+		if in.Favorites[i].ID == "" {
+			in.Favorites[i].ID = "FavList-" + uuid.NewString()
+		}
+	}
+	// 歌曲引用在下面按顺序单独写入，避免 Save 连带插入
+	if len(in.Favorites) > 0 {
+		if err := tx.Omit("SongIDs").Save(&in.Favorites).Error; err != nil {
 			return err
 		}
-		if err := tx.Exec("DELETE FROM lyric_mappings").Error; err != nil {
+	}
+	for i := range in.Favorites {
+		for j := range in.Favorites[i].SongIDs {
+			ref := &in.Favorites[i].SongIDs[j]
+			ref.FavoriteID = in.Favorites[i].ID
+			// 旧备份没有 position/addedAt，按原顺序补齐
+			if ref.Position == 0 {
+				ref.Position = int64(j+1) * songRefPositionGap
+			}
+			if ref.AddedAt.IsZero() {
+				ref.AddedAt = in.Favorites[i].CreatedAt
+			}
+		}
+		if len(in.Favorites[i].SongIDs) == 0 {
+			continue
+		}
+		if err := tx.Create(&in.Favorites[i].SongIDs).Error; err != nil {
 			return err
 		}
-		if err := tx.Save(&in.Songs).Error; err != nil {
+	}
+	if err := tx.Save(&in.Settings).Error; err != nil {
+		return err
+	}
+	if len(in.Lyrics) > 0 {
+		if err := tx.Save(&in.Lyrics).Error; err != nil {
 			return err
 		}
-		for i := range in.Favorites {
-			if in.Favorites[i].ID == "" {
-				in.Favorites[i].ID = "FavList-" + uuid.NewString()
-			}
+	}
+	if len(in.LyricTracks) > 0 {
+		if err := tx.Save(&in.LyricTracks).Error; err != nil {
+			return err
 		}
-		if err := tx.Save(&in.Favorites).Error; err != nil {
+	}
+	if len(in.Folders) > 0 {
+		if err := tx.Save(&in.Folders).Error; err != nil {
 			return err
 		}
-		for i := range in.Favorites {
-			for j := range in.Favorites[i].SongIDs {
-				in.Favorites[i].SongIDs[j].FavoriteID = in.Favorites[i].ID
-			}
-			if err := tx.Create(&in.Favorites[i].SongIDs).Error; err != nil {
-				return err
-			}
+	}
+	if len(in.FavoriteTags) > 0 {
+		if err := tx.Save(&in.FavoriteTags).Error; err != nil {
+			return err
 		}
-		if err := tx.Save(&in.Settings).Error; err != nil {
+	}
+	if len(in.SongTags) > 0 {
+		if err := tx.Save(&in.SongTags).Error; err != nil {
 			return err
 		}
-		if err := tx.Save(&in.Lyrics).Error; err != nil {
+	}
+	if len(in.BiliLinks) > 0 {
+		if err := tx.Save(&in.BiliLinks).Error; err != nil {
 			return err
 		}
-		return nil
-	})
+	}
+	return nil
 }
 
-// ClearLibrary removes all songs, favorites, and lyric mappings, then seeds an empty default favorite.
+// ClearLibrary removes all songs, favorites, lyrics, folders, tags and Bilibili links, then seeds an empty default favorite.
 func (s *Service) ClearLibrary() error {
+	if err := s.snapshotBefore(SnapshotBeforeClear); err != nil {
+		return err
+	}
 	return s.db.Transaction(func(tx *gorm.DB) error {
 		if err := tx.Exec("DELETE FROM song_refs").Error; err != nil {
 			return err
@@ -88,6 +174,14 @@ func (s *Service) ClearLibrary() error {
 		if err := tx.Exec("DELETE FROM lyric_mappings").Error; err != nil {
 			return err
 		}
+		if err := tx.Exec("DELETE FROM lyric_tracks").Error; err != nil {
+			return err
+		}
+		for _, table := range []string{"favorite_folders", "favorite_tags", "song_tags", "bili_favorite_links"} {
+			if err := tx.Exec("DELETE FROM " + table).Error; err != nil {
+				return err
+			}
+		}
 		seed := models.Favorite{ID: "FavList-default", Title: "默认歌单"}
 		if err := tx.Create(&seed).Error; err != nil {
 			return err
@@ -0,0 +1,579 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"half-beat-player/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Conflict strategies of MergeImportData.
+const (
+	ImportKeepLocal    = "keep-local"    // 保留本地数据
+	ImportTakeIncoming = "take-incoming" // 使用备份中的数据
+	ImportNewest       = "newest"        // UpdatedAt 较新的一方胜出
+)
+
+// Entities counted by MergeImportData.
+const (
+	importEntitySongs        = "songs"
+	importEntityFolders      = "folders"
+	importEntityFavorites    = "favorites"
+	importEntityFavoriteTags = "favoriteTags"
+	importEntitySongTags     = "songTags"
+	importEntityBiliLinks    = "biliLinks"
+	importEntityLyrics       = "lyrics"
+	importEntityLyricTracks  = "lyricTracks"
+	importEntitySettings     = "settings"
+)
+
+// errImportDryRun rolls back the merge transaction of a dry run.
+var errImportDryRun = errors.New("dry run")
+
+// ImportMergeOptions picks a conflict strategy per entity; empty means
+// newest. Favorites also covers folders and Bilibili links, Lyrics covers
+// lyric tracks.
+type ImportMergeOptions struct {
+	Songs     string `json:"songs"`
+	Favorites string `json:"favorites"`
+	Lyrics    string `json:"lyrics"`
+	Settings  string `json:"settings"`
+	DryRun    bool   `json:"dryRun"` // 只统计，不写入
+}
+
+// ImportEntityCounts counts what a merge did to one kind of entity.
+type ImportEntityCounts struct {
+	Added     int `json:"added"`
+	Updated   int `json:"updated"`   // 冲突时采用了备份中的数据
+	Kept      int `json:"kept"`      // 冲突时保留了本地数据
+	Unchanged int `json:"unchanged"` // 两边相同
+}
+
+// ImportConflict is an entity present on both sides with different content.
+type ImportConflict struct {
+	Entity            string    `json:"entity"`
+	ID                string    `json:"id"`                   // 本地 ID
+	IncomingID        string    `json:"incomingId,omitempty"` // 与本地不同时为备份中的 ID
+	Title             string    `json:"title"`
+	LocalUpdatedAt    time.Time `json:"localUpdatedAt"`
+	IncomingUpdatedAt time.Time `json:"incomingUpdatedAt"`
+	Resolution        string    `json:"resolution"` // local 或 incoming
+}
+
+// ImportMergeResult reports what MergeImportData did, or would do on a dry run.
+type ImportMergeResult struct {
+	DryRun    bool                          `json:"dryRun"`
+	Counts    map[string]ImportEntityCounts `json:"counts"`
+	Conflicts []ImportConflict              `json:"conflicts"`
+}
+
+// importMerge holds the state of one merge.
+type importMerge struct {
+	tx     *gorm.DB
+	opts   ImportMergeOptions
+	result *ImportMergeResult
+	// 备份中的歌曲 ID -> 本地歌曲 ID
+	songIDs map[string]string
+}
+
+func validImportStrategy(strategy string) bool {
+	switch strategy {
+	case "", ImportKeepLocal, ImportTakeIncoming, ImportNewest:
+		return true
+	}
+	return false
+}
+
+// MergeImportData merges a backup into the library instead of replacing it.
+// Songs are matched by id, then by BV number and page (segments also by
+// their skip window); favorites, folders and lyrics by id. Entities present
+// on both sides with different content are resolved with the strategy for
+// their kind and listed as conflicts. With DryRun nothing is written.
+func (s *Service) MergeImportData(in ExportData, opts ImportMergeOptions) (ImportMergeResult, error) {
+	for _, strategy := range []string{opts.Songs, opts.Favorites, opts.Lyrics, opts.Settings} {
+		if !validImportStrategy(strategy) {
+			return ImportMergeResult{}, fmt.Errorf("未知的冲突策略: %s", strategy)
+		}
+	}
+	result := ImportMergeResult{
+		DryRun:    opts.DryRun,
+		Counts:    map[string]ImportEntityCounts{},
+		Conflicts: []ImportConflict{},
+	}
+	if !opts.DryRun {
+		if err := s.snapshotBefore(SnapshotBeforeMerge); err != nil {
+			return result, err
+		}
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		m := &importMerge{tx: tx, opts: opts, result: &result, songIDs: map[string]string{}}
+		steps := []func(ExportData) error{
+			m.mergeSongs,
+			m.mergeFolders,
+			m.mergeFavorites,
+			m.mergeTags,
+			m.mergeBiliLinks,
+			m.mergeLyrics,
+			m.mergeLyricTracks,
+			m.mergeSettings,
+		}
+		for _, step := range steps {
+			if err := step(in); err != nil {
+				return err
+			}
+		}
+		if opts.DryRun {
+			return errImportDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errImportDryRun) {
+		err = nil
+	}
+	return result, err
+}
+
+func (m *importMerge) count(entity string, update func(c *ImportEntityCounts)) {
+	c := m.result.Counts[entity]
+	update(&c)
+	m.result.Counts[entity] = c
+}
+
+// resolve records a conflict and reports whether the incoming side wins.
+func (m *importMerge) resolve(entity, strategy string, c ImportConflict) bool {
+	var incoming bool
+	switch strategy {
+	case ImportKeepLocal:
+		incoming = false
+	case ImportTakeIncoming:
+		incoming = true
+	default:
+		incoming = c.IncomingUpdatedAt.After(c.LocalUpdatedAt)
+	}
+	c.Entity = entity
+	c.Resolution = "local"
+	if incoming {
+		c.Resolution = "incoming"
+	}
+	if c.IncomingID == c.ID {
+		c.IncomingID = ""
+	}
+	m.result.Conflicts = append(m.result.Conflicts, c)
+	m.count(entity, func(n *ImportEntityCounts) {
+		if incoming {
+			n.Updated++
+		} else {
+			n.Kept++
+		}
+	})
+	return incoming
+}
+
+// saveIncoming saves a row taken from the backup and keeps its UpdatedAt, so
+// that a later newest-wins merge compares the original edit times.
+func (m *importMerge) saveIncoming(value any, updatedAt time.Time) error {
+	if err := m.tx.Save(value).Error; err != nil {
+		return err
+	}
+	if updatedAt.IsZero() {
+		return nil
+	}
+	return m.tx.Model(value).UpdateColumn("updated_at", updatedAt).Error
+}
+
+// localSongID maps a song id of the backup to the library.
+func (m *importMerge) localSongID(id string) string {
+	if local, ok := m.songIDs[id]; ok {
+		return local
+	}
+	return id
+}
+
+// songMergeKey identifies the same recording across libraries.
+func songMergeKey(song models.Song) string {
+	if song.BVID == "" {
+		return ""
+	}
+	key := fmt.Sprintf("%s|%d", song.BVID, max(song.PageNumber, 1))
+	if isSegmentSong(song) {
+		key += fmt.Sprintf("|%.1f|%.1f", song.SkipStartTime, segmentEnd(song))
+	}
+	return key
+}
+
+// sameSongContent compares the user-visible fields of two songs; stream and
+// cover caches are machine specific and ignored.
+func sameSongContent(a, b models.Song) bool {
+	return a.BVID == b.BVID && a.Name == b.Name && a.Singer == b.Singer && a.SingerID == b.SingerID &&
+		a.Cover == b.Cover && a.Lyric == b.Lyric && a.LyricOffset == b.LyricOffset &&
+		math.Abs(a.SkipStartTime-b.SkipStartTime) < 0.001 && math.Abs(a.SkipEndTime-b.SkipEndTime) < 0.001 &&
+		max(a.PageNumber, 1) == max(b.PageNumber, 1) && a.PageTitle == b.PageTitle &&
+		a.VideoTitle == b.VideoTitle && a.TotalPages == b.TotalPages
+}
+
+func (m *importMerge) mergeSongs(in ExportData) error {
+	var local []models.Song
+	if err := m.tx.Find(&local).Error; err != nil {
+		return err
+	}
+	byID := make(map[string]*models.Song, len(local))
+	byKey := make(map[string]*models.Song, len(local))
+	for i := range local {
+		byID[local[i].ID] = &local[i]
+		if key := songMergeKey(local[i]); key != "" {
+			if _, ok := byKey[key]; !ok {
+				byKey[key] = &local[i]
+			}
+		}
+	}
+
+	for _, song := range in.Songs {
+		key := songMergeKey(song)
+		cur := byID[song.ID]
+		if cur == nil && key != "" {
+			cur = byKey[key]
+		}
+		if cur == nil {
+			if err := m.tx.Create(&song).Error; err != nil {
+				return err
+			}
+			added := song
+			byID[added.ID] = &added
+			if key != "" {
+				byKey[key] = &added
+			}
+			m.count(importEntitySongs, func(c *ImportEntityCounts) { c.Added++ })
+			continue
+		}
+		m.songIDs[song.ID] = cur.ID
+		if sameSongContent(*cur, song) {
+			m.count(importEntitySongs, func(c *ImportEntityCounts) { c.Unchanged++ })
+			continue
+		}
+		take := m.resolve(importEntitySongs, m.opts.Songs, ImportConflict{
+			ID: cur.ID, IncomingID: song.ID, Title: song.Name,
+			LocalUpdatedAt: cur.UpdatedAt, IncomingUpdatedAt: song.UpdatedAt,
+		})
+		if !take {
+			continue
+		}
+		updated := song
+		updated.ID = cur.ID
+		updated.CreatedAt = cur.CreatedAt
+		// 播放地址与封面缓存只在本机有效
+		updated.SourceID, updated.StreamURL, updated.StreamURLExpiresAt = cur.SourceID, cur.StreamURL, cur.StreamURLExpiresAt
+		updated.CoverLocal = cur.CoverLocal
+		if updated.Duration == 0 {
+			updated.Duration = cur.Duration
+		}
+		if err := m.saveIncoming(&updated, song.UpdatedAt); err != nil {
+			return err
+		}
+		*cur = updated
+	}
+	return nil
+}
+
+func (m *importMerge) mergeFolders(in ExportData) error {
+	for _, folder := range in.Folders {
+		var cur models.FavoriteFolder
+		if err := m.tx.Where("id = ?", folder.ID).Limit(1).Find(&cur).Error; err != nil {
+			return err
+		}
+		if cur.ID == "" {
+			if err := m.tx.Create(&folder).Error; err != nil {
+				return err
+			}
+			m.count(importEntityFolders, func(c *ImportEntityCounts) { c.Added++ })
+			continue
+		}
+		if cur.Name == folder.Name && cur.ParentID == folder.ParentID && cur.Position == folder.Position && cur.Collapsed == folder.Collapsed {
+			m.count(importEntityFolders, func(c *ImportEntityCounts) { c.Unchanged++ })
+			continue
+		}
+		if m.resolve(importEntityFolders, m.opts.Favorites, ImportConflict{
+			ID: cur.ID, Title: folder.Name, LocalUpdatedAt: cur.UpdatedAt, IncomingUpdatedAt: folder.UpdatedAt,
+		}) {
+			folder.CreatedAt = cur.CreatedAt
+			if err := m.saveIncoming(&folder, folder.UpdatedAt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// incomingRefs maps the refs of a backup favorite onto library songs,
+// dropping refs to songs that exist on neither side.
+func (m *importMerge) incomingRefs(fav models.Favorite) ([]models.SongRef, error) {
+	refs := make([]models.SongRef, 0, len(fav.SongIDs))
+	for i, ref := range fav.SongIDs {
+		id := m.localSongID(ref.SongID)
+		var count int64
+		if err := m.tx.Model(&models.Song{}).Where("id = ?", id).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			continue
+		}
+		addedAt := ref.AddedAt
+		if addedAt.IsZero() {
+			addedAt = fav.CreatedAt
+		}
+		refs = append(refs, models.SongRef{
+			FavoriteID: fav.ID,
+			SongID:     id,
+			Position:   int64(i+1) * songRefPositionGap,
+			AddedAt:    addedAt,
+		})
+	}
+	return refs, nil
+}
+
+func sameFavoriteContent(a, b models.Favorite, aRefs, bRefs []models.SongRef) bool {
+	if a.Title != b.Title || a.Kind != b.Kind || a.FolderID != b.FolderID {
+		return false
+	}
+	ar, _ := json.Marshal(a.Rules)
+	br, _ := json.Marshal(b.Rules)
+	if string(ar) != string(br) || len(aRefs) != len(bRefs) {
+		return false
+	}
+	for i := range aRefs {
+		if aRefs[i].SongID != bRefs[i].SongID {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *importMerge) mergeFavorites(in ExportData) error {
+	for _, fav := range in.Favorites {
+		if fav.ID == "" {
+			continue
+		}
+		refs, err := m.incomingRefs(fav)
+		if err != nil {
+			return err
+		}
+		fav.SongIDs = nil
+
+		var cur models.Favorite
+		if err := m.tx.Where("id = ?", fav.ID).Limit(1).Find(&cur).Error; err != nil {
+			return err
+		}
+		if cur.ID == "" {
+			if err := m.tx.Create(&fav).Error; err != nil {
+				return err
+			}
+			if len(refs) > 0 {
+				if err := m.tx.Create(&refs).Error; err != nil {
+					return err
+				}
+			}
+			m.count(importEntityFavorites, func(c *ImportEntityCounts) { c.Added++ })
+			continue
+		}
+
+		curRefs, err := orderedSongRefs(m.tx, cur.ID)
+		if err != nil {
+			return err
+		}
+		if sameFavoriteContent(cur, fav, curRefs, refs) {
+			m.count(importEntityFavorites, func(c *ImportEntityCounts) { c.Unchanged++ })
+			continue
+		}
+		if !m.resolve(importEntityFavorites, m.opts.Favorites, ImportConflict{
+			ID: cur.ID, Title: fav.Title, LocalUpdatedAt: cur.UpdatedAt, IncomingUpdatedAt: fav.UpdatedAt,
+		}) {
+			continue
+		}
+		fav.CreatedAt = cur.CreatedAt
+		if err := m.saveIncoming(&fav, fav.UpdatedAt); err != nil {
+			return err
+		}
+		if err := m.tx.Where("favorite_id = ?", fav.ID).Delete(&models.SongRef{}).Error; err != nil {
+			return err
+		}
+		if len(refs) > 0 {
+			if err := m.tx.Create(&refs).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mergeTags adds the tags of the backup; tags are sets, so they never conflict.
+func (m *importMerge) mergeTags(in ExportData) error {
+	for _, tag := range in.FavoriteTags {
+		res := m.tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tag)
+		if res.Error != nil {
+			return res.Error
+		}
+		m.count(importEntityFavoriteTags, func(c *ImportEntityCounts) {
+			if res.RowsAffected > 0 {
+				c.Added++
+			} else {
+				c.Unchanged++
+			}
+		})
+	}
+	for _, tag := range in.SongTags {
+		tag.SongID = m.localSongID(tag.SongID)
+		res := m.tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&tag)
+		if res.Error != nil {
+			return res.Error
+		}
+		m.count(importEntitySongTags, func(c *ImportEntityCounts) {
+			if res.RowsAffected > 0 {
+				c.Added++
+			} else {
+				c.Unchanged++
+			}
+		})
+	}
+	return nil
+}
+
+func (m *importMerge) mergeBiliLinks(in ExportData) error {
+	for _, link := range in.BiliLinks {
+		var favCount int64
+		if err := m.tx.Model(&models.Favorite{}).Where("id = ?", link.FavoriteID).Count(&favCount).Error; err != nil {
+			return err
+		}
+		if favCount == 0 {
+			continue
+		}
+		var cur models.BiliFavoriteLink
+		if err := m.tx.Where("favorite_id = ?", link.FavoriteID).Limit(1).Find(&cur).Error; err != nil {
+			return err
+		}
+		if cur.FavoriteID == "" {
+			if err := m.tx.Create(&link).Error; err != nil {
+				return err
+			}
+			m.count(importEntityBiliLinks, func(c *ImportEntityCounts) { c.Added++ })
+			continue
+		}
+		if cur.MediaID == link.MediaID && biliLinkKind(cur) == biliLinkKind(link) && cur.Policy == link.Policy {
+			m.count(importEntityBiliLinks, func(c *ImportEntityCounts) { c.Unchanged++ })
+			continue
+		}
+		if m.resolve(importEntityBiliLinks, m.opts.Favorites, ImportConflict{
+			ID: cur.FavoriteID, Title: link.RemoteTitle, LocalUpdatedAt: cur.UpdatedAt, IncomingUpdatedAt: link.UpdatedAt,
+		}) {
+			link.CreatedAt = cur.CreatedAt
+			if err := m.saveIncoming(&link, link.UpdatedAt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *importMerge) mergeLyrics(in ExportData) error {
+	for _, lyric := range in.Lyrics {
+		incomingID := lyric.ID
+		lyric.ID = m.localSongID(lyric.ID)
+		var cur models.LyricMapping
+		if err := m.tx.Where("id = ?", lyric.ID).Limit(1).Find(&cur).Error; err != nil {
+			return err
+		}
+		if cur.ID == "" {
+			if err := m.tx.Create(&lyric).Error; err != nil {
+				return err
+			}
+			m.count(importEntityLyrics, func(c *ImportEntityCounts) { c.Added++ })
+			continue
+		}
+		if cur.Lyric == lyric.Lyric && cur.OffsetMS == lyric.OffsetMS && cur.Language == lyric.Language {
+			m.count(importEntityLyrics, func(c *ImportEntityCounts) { c.Unchanged++ })
+			continue
+		}
+		if m.resolve(importEntityLyrics, m.opts.Lyrics, ImportConflict{
+			ID: cur.ID, IncomingID: incomingID, LocalUpdatedAt: cur.UpdatedAt, IncomingUpdatedAt: lyric.UpdatedAt,
+		}) {
+			if err := m.saveIncoming(&lyric, lyric.UpdatedAt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *importMerge) mergeLyricTracks(in ExportData) error {
+	for _, track := range in.LyricTracks {
+		track.SongID = m.localSongID(track.SongID)
+		var cur models.LyricTrack
+		if err := m.tx.Where("song_id = ? AND kind = ? AND language = ?", track.SongID, track.Kind, track.Language).
+			Limit(1).Find(&cur).Error; err != nil {
+			return err
+		}
+		if cur.ID == "" {
+			var idCount int64
+			if err := m.tx.Model(&models.LyricTrack{}).Where("id = ?", track.ID).Count(&idCount).Error; err != nil {
+				return err
+			}
+			if idCount > 0 {
+				// 同一 ID 在本地属于别的歌曲，换一个 ID
+				track.ID = fmt.Sprintf("%s-%s-%s", track.SongID, track.Kind, track.Language)
+			}
+			if err := m.tx.Create(&track).Error; err != nil {
+				return err
+			}
+			m.count(importEntityLyricTracks, func(c *ImportEntityCounts) { c.Added++ })
+			continue
+		}
+		if cur.Lyric == track.Lyric && cur.OffsetMS == track.OffsetMS {
+			m.count(importEntityLyricTracks, func(c *ImportEntityCounts) { c.Unchanged++ })
+			continue
+		}
+		if m.resolve(importEntityLyricTracks, m.opts.Lyrics, ImportConflict{
+			ID: cur.ID, IncomingID: track.ID, Title: track.Kind, LocalUpdatedAt: cur.UpdatedAt, IncomingUpdatedAt: track.UpdatedAt,
+		}) {
+			track.ID, track.CreatedAt = cur.ID, cur.CreatedAt
+			if err := m.saveIncoming(&track, track.UpdatedAt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m *importMerge) mergeSettings(in ExportData) error {
+	if in.Settings.Config == nil {
+		return nil
+	}
+	incoming := in.Settings
+	var cur models.PlayerSetting
+	if err := m.tx.Order("id").Limit(1).Find(&cur).Error; err != nil {
+		return err
+	}
+	if cur.ID == 0 {
+		if err := m.tx.Create(&incoming).Error; err != nil {
+			return err
+		}
+		m.count(importEntitySettings, func(c *ImportEntityCounts) { c.Added++ })
+		return nil
+	}
+	a, _ := json.Marshal(cur.Config)
+	b, _ := json.Marshal(incoming.Config)
+	if string(a) == string(b) {
+		m.count(importEntitySettings, func(c *ImportEntityCounts) { c.Unchanged++ })
+		return nil
+	}
+	if m.resolve(importEntitySettings, m.opts.Settings, ImportConflict{
+		ID: fmt.Sprint(cur.ID), LocalUpdatedAt: cur.UpdatedAt, IncomingUpdatedAt: incoming.UpdatedAt,
+	}) {
+		incoming.ID = cur.ID
+		return m.saveIncoming(&incoming, incoming.UpdatedAt)
+	}
+	return nil
+}
@@ -156,15 +156,17 @@ func (s *Service) IsLoggedIn() bool {
 	return false
 }
 
-// saveCookies 将 SESSDATA cookie 保存到文件
+// saveCookies 将 SESSDATA 与 bili_jct cookie 保存到数据库
 func (s *Service) saveCookies() error {
 	cookies := s.cookieJar.Cookies(&url.URL{Scheme: "https", Host: "www.bilibili.com"})
 
-	var sessdataValue string
+	var sessdataValue, biliJct string
 	for _, c := range cookies {
 		if c.Name == "SESSDATA" && c.Value != "" {
 			sessdataValue = c.Value
-			break
+		}
+		if c.Name == "bili_jct" && c.Value != "" {
+			biliJct = c.Value
 		}
 	}
 
@@ -182,6 +184,7 @@ func (s *Service) saveCookies() error {
 	session := models.LoginSession{
 		ID:       1,
 		Sessdata: sessdataValue,
+		BiliJct:  biliJct,
 		SavedAt:  time.Now(),
 	}
 	if err := s.db.Save(&session).Error; err != nil {
@@ -212,6 +215,16 @@ func (s *Service) restoreLogin() error {
 					Secure:   true,
 				},
 			}
+			if session.BiliJct != "" {
+				cookies = append(cookies, &http.Cookie{
+					Name:    "bili_jct",
+					Value:   session.BiliJct,
+					Path:    "/",
+					Domain:  ".bilibili.com",
+					Expires: time.Now().AddDate(0, 1, 0),
+					Secure:  true,
+				})
+			}
 			s.cookieJar.SetCookies(biliURL, cookies)
 		}
 		return nil
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"half-beat-player/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// 自动响度均衡相关常量
+const (
+	defaultTargetLUFS = -14.0 // B 站 playurl 默认目标响度
+	maxTruePeakDb     = -1.0  // 提升增益时保证真峰值不超过 -1 dBTP
+	maxAutoGainDb     = 12.0  // 自动增益的绝对值上限
+)
+
+// Song gain sources reported by GetSongGain.
+const (
+	GainSourceManual = "manual" // 用户在 songVolumeOffsets 中手动设置
+	GainSourceAuto   = "auto"   // 根据 B 站响度数据自动计算
+	GainSourceGlobal = "global" // 使用全局 volumeCompensationDb
+)
+
+// SongGain describes the effective volume offset for a song and where it came from.
+type SongGain struct {
+	SongID      string                `json:"songId"`
+	GainDb      float64               `json:"gainDb"`
+	Source      string                `json:"source"`
+	SuggestedDb *float64              `json:"suggestedDb,omitempty"`
+	Loudness    *models.AudioLoudness `json:"loudness,omitempty"`
+}
+
+func loudnessID(bvid string, page int) string {
+	if page <= 0 {
+		page = 1
+	}
+	return fmt.Sprintf("%s-P%d", bvid, page)
+}
+
+// suggestedGainDb computes the gain (dB) that brings a stream to the target loudness.
+// Positive gains are limited so that the measured true peak stays below maxTruePeakDb.
+func suggestedGainDb(l models.AudioLoudness) float64 {
+	gain := l.TargetOffset
+	if gain == 0 {
+		target := l.TargetI
+		if target == 0 {
+			target = defaultTargetLUFS
+		}
+		gain = target - l.MeasuredI
+	}
+	if gain > 0 && l.MeasuredTP != 0 && l.MeasuredTP+gain > maxTruePeakDb {
+		gain = math.Max(0, maxTruePeakDb-l.MeasuredTP)
+	}
+	gain = math.Max(-maxAutoGainDb, math.Min(maxAutoGainDb, gain))
+	return math.Round(gain*10) / 10
+}
+
+func (s *Service) saveAudioLoudness(l *models.AudioLoudness) error {
+	if l == nil || l.ID == "" {
+		return fmt.Errorf("loudness id required")
+	}
+	l.UpdatedAt = time.Now()
+	return s.db.Save(l).Error
+}
+
+// GetSongLoudness returns stored loudness data for a song, or nil if none is known yet.
+func (s *Service) GetSongLoudness(songID string) (*models.AudioLoudness, error) {
+	var song models.Song
+	if err := s.db.First(&song, "id = ?", songID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("未找到歌曲: %s", songID)
+		}
+		return nil, err
+	}
+	if song.BVID == "" {
+		return nil, nil
+	}
+
+	var l models.AudioLoudness
+	if err := s.db.First(&l, "id = ?", loudnessID(song.BVID, song.PageNumber)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &l, nil
+}
+
+// GetSongGain resolves the volume offset for a song.
+// Priority: manual songVolumeOffsets > auto-normalise (when enabled and data exists) > global compensation.
+func (s *Service) GetSongGain(songID string) (SongGain, error) {
+	setting, err := s.GetPlayerSetting()
+	if err != nil {
+		return SongGain{}, err
+	}
+
+	out := SongGain{
+		SongID: songID,
+		GainDb: getConfigFloat(setting.Config, "volumeCompensationDb", 0),
+		Source: GainSourceGlobal,
+	}
+
+	loudness, err := s.GetSongLoudness(songID)
+	if err != nil {
+		return SongGain{}, err
+	}
+	if loudness != nil {
+		suggested := loudness.SuggestedGainDb
+		out.SuggestedDb = &suggested
+		out.Loudness = loudness
+	}
+
+	if offsets, ok := setting.Config["songVolumeOffsets"].(map[string]any); ok {
+		if v, ok := offsets[songID].(float64); ok {
+			out.GainDb = v
+			out.Source = GainSourceManual
+			return out, nil
+		}
+	}
+
+	if getConfigBool(setting.Config, "autoNormalize", false) && out.SuggestedDb != nil {
+		out.GainDb = *out.SuggestedDb
+		out.Source = GainSourceAuto
+	}
+	return out, nil
+}
@@ -0,0 +1,331 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"half-beat-player/internal/lrc"
+	"half-beat-player/internal/models"
+	"half-beat-player/internal/textmatch"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"gorm.io/gorm"
+)
+
+// How an .lrc file was matched to a song.
+const (
+	LrcMatchFilename = "filename"
+	LrcMatchTags     = "tags"
+	LrcMatchFuzzy    = "fuzzy"
+)
+
+// lrcMatchThreshold is the minimum fuzzy score for a file to be matched at all.
+const lrcMatchThreshold = 0.6
+
+// LrcFileMatch is one scanned .lrc file and the library song it best matches.
+// SongID is empty when no song scored above the threshold.
+type LrcFileMatch struct {
+	Path      string  `json:"path"`
+	FileName  string  `json:"fileName"`
+	Title     string  `json:"title"`  // [ti:] 标签
+	Artist    string  `json:"artist"` // [ar:] 标签
+	Lines     int     `json:"lines"`
+	SongID    string  `json:"songId"`
+	SongName  string  `json:"songName"`
+	Singer    string  `json:"singer"`
+	Score     float64 `json:"score"`
+	MatchedBy string  `json:"matchedBy"`
+	HasLyric  bool    `json:"hasLyric"` // 歌曲已有歌词，导入会覆盖
+	Encoding  string  `json:"encoding"` // 文件编码：utf-8 或 gb18030
+	Error     string  `json:"error,omitempty"`
+}
+
+// LrcImportResult summarises a bulk lyric import.
+type LrcImportResult struct {
+	Imported int      `json:"imported"`
+	Skipped  int      `json:"skipped"`
+	Failed   []string `json:"failed"`
+}
+
+// LrcExportResult summarises a bulk lyric export.
+type LrcExportResult struct {
+	Dir     string `json:"dir"`
+	Written int    `json:"written"`
+}
+
+// Encodings readLrcFile understands.
+const (
+	lrcEncodingUTF8    = "utf-8"
+	lrcEncodingGB18030 = "gb18030"
+)
+
+// readLrcFile reads an .lrc file as UTF-8, falling back to GB18030 (a
+// superset of GBK/GB2312, which most Chinese .lrc files use). It returns the
+// text and the encoding it was decoded from.
+func readLrcFile(path string) (string, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if utf8.Valid(data) {
+		return string(data), lrcEncodingUTF8, nil
+	}
+	decoded, err := simplifiedchinese.GB18030.NewDecoder().Bytes(data)
+	// 解码器把无法识别的字节替换为 U+FFFD，出现即说明不是 GBK 系编码
+	if err != nil || bytes.ContainsRune(decoded, utf8.RuneError) {
+		return "", "", fmt.Errorf("无法识别的文件编码，请转换为 UTF-8 或 GBK")
+	}
+	return string(decoded), lrcEncodingGB18030, nil
+}
+
+// lrcFileTitles derives candidate title/artist pairs from a file name such as
+// "Singer - Title.lrc", "Title - Singer.lrc" or "01. Title.lrc".
+func lrcFileTitles(name string) [][2]string {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	out := [][2]string{{base, ""}}
+	// 去掉音轨序号，但标题本身可能就是数字，所以两者都保留
+	if trimmed := strings.TrimLeft(base, "0123456789. -_"); trimmed != "" && trimmed != base {
+		out = append(out, [2]string{trimmed, ""})
+		base = trimmed
+	}
+	if parts := strings.SplitN(base, " - ", 2); len(parts) == 2 {
+		a, b := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		out = append(out, [2]string{b, a}, [2]string{a, b})
+	}
+	return out
+}
+
+// scoreLrcMatch rates how well a title/artist pair matches a song.
+func scoreLrcMatch(title, artist string, song models.Song) float64 {
+	name := textmatch.CleanTitle(song.Name)
+	score := textmatch.Similarity(title, name)
+	if artist != "" && song.Singer != "" {
+		score = score*0.8 + textmatch.Similarity(artist, song.Singer)*0.2
+	}
+	return score
+}
+
+// matchLrcFile finds the best library song for a scanned file.
+func matchLrcFile(m *LrcFileMatch, songs []models.Song) {
+	fileTitles := lrcFileTitles(m.FileName)
+	for _, song := range songs {
+		name := textmatch.Normalize(textmatch.CleanTitle(song.Name))
+		best, by := 0.0, LrcMatchFuzzy
+
+		for _, ft := range fileTitles {
+			sc := scoreLrcMatch(ft[0], ft[1], song)
+			if textmatch.Normalize(ft[0]) == name && sc > best {
+				best, by = sc, LrcMatchFilename
+			} else if sc > best {
+				best, by = sc, LrcMatchFuzzy
+			}
+		}
+		if m.Title != "" {
+			sc := scoreLrcMatch(m.Title, m.Artist, song)
+			if textmatch.Normalize(m.Title) == name && sc >= best {
+				best, by = sc, LrcMatchTags
+			} else if sc > best {
+				best, by = sc, LrcMatchFuzzy
+			}
+		}
+		if best >= lrcMatchThreshold && best > m.Score {
+			m.SongID = song.ID
+			m.SongName = song.Name
+			m.Singer = song.Singer
+			m.Score = best
+			m.MatchedBy = by
+		}
+	}
+}
+
+// ScanLyricFolder reads every .lrc file under dir and proposes a library song
+// for each one. Nothing is written; pass the reviewed list to ImportLyricFiles.
+func (s *Service) ScanLyricFolder(dir string, recursive bool) ([]LrcFileMatch, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("无法访问目录: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s 不是目录", dir)
+	}
+
+	var paths []string
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.EqualFold(filepath.Ext(path), ".lrc") {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("扫描目录失败: %w", err)
+	}
+	sort.Strings(paths)
+
+	var songs []models.Song
+	if err := s.db.Select("id", "name", "singer").Find(&songs).Error; err != nil {
+		return nil, err
+	}
+	var withLyric []string
+	if err := s.db.Model(&models.LyricMapping{}).Where("lyric <> ''").Pluck("id", &withLyric).Error; err != nil {
+		return nil, err
+	}
+	hasLyric := make(map[string]bool, len(withLyric))
+	for _, id := range withLyric {
+		hasLyric[id] = true
+	}
+
+	out := make([]LrcFileMatch, 0, len(paths))
+	for _, path := range paths {
+		m := LrcFileMatch{Path: path, FileName: filepath.Base(path)}
+		text, encoding, err := readLrcFile(path)
+		if err != nil {
+			m.Error = err.Error()
+			out = append(out, m)
+			continue
+		}
+		m.Encoding = encoding
+		doc := lrc.Parse(text)
+		m.Title = doc.Meta["ti"]
+		m.Artist = doc.Meta["ar"]
+		m.Lines = len(doc.Lines)
+		matchLrcFile(&m, songs)
+		m.HasLyric = m.SongID != "" && hasLyric[m.SongID]
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// ImportLyricFiles writes the reviewed matches as lyric mappings in one
+// transaction. Songs that already have lyrics are skipped unless overwrite is set.
+func (s *Service) ImportLyricFiles(matches []LrcFileMatch, overwrite bool) (LrcImportResult, error) {
+	res := LrcImportResult{Failed: []string{}}
+	mappings := make([]models.LyricMapping, 0, len(matches))
+	seen := make(map[string]bool, len(matches))
+	now := time.Now()
+
+	for _, m := range matches {
+		if m.SongID == "" || seen[m.SongID] {
+			res.Skipped++
+			continue
+		}
+		text, _, err := readLrcFile(m.Path)
+		if err != nil {
+			res.Failed = append(res.Failed, fmt.Sprintf("%s: %v", m.FileName, err))
+			continue
+		}
+		seen[m.SongID] = true
+		mappings = append(mappings, models.LyricMapping{ID: m.SongID, Lyric: text, UpdatedAt: now})
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for _, mapping := range mappings {
+			var existing models.LyricMapping
+			err := tx.Where("id = ?", mapping.ID).Limit(1).Find(&existing).Error
+			if err != nil {
+				return err
+			}
+			if existing.Lyric != "" && !overwrite {
+				res.Skipped++
+				continue
+			}
+			// 保留已有的偏移和语言设置
+			mapping.OffsetMS = existing.OffsetMS
+			mapping.Language = existing.Language
+			if err := tx.Save(&mapping).Error; err != nil {
+				return err
+			}
+			res.Imported++
+		}
+		return nil
+	})
+	if err != nil {
+		return LrcImportResult{}, err
+	}
+	return res, nil
+}
+
+// lrcFileName builds a file-system safe "Singer - Name.lrc" file name.
+func lrcFileName(song models.Song) string {
+	name := song.Name
+	if song.Singer != "" {
+		name = song.Singer + " - " + name
+	}
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < 0x20 {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.Trim(strings.TrimSpace(name), ".")
+	if name == "" {
+		name = song.ID
+	}
+	return name + ".lrc"
+}
+
+// ExportLyricsToFolder writes every song's lyric to dir as "Singer - Name.lrc".
+// Songs without lyrics are skipped; clashing names get a numeric suffix.
+func (s *Service) ExportLyricsToFolder(dir string) (LrcExportResult, error) {
+	if strings.TrimSpace(dir) == "" {
+		return LrcExportResult{}, fmt.Errorf("导出目录不能为空")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return LrcExportResult{}, fmt.Errorf("创建目录失败: %w", err)
+	}
+
+	var songs []models.Song
+	if err := s.db.Order("name").Find(&songs).Error; err != nil {
+		return LrcExportResult{}, err
+	}
+
+	res := LrcExportResult{Dir: dir}
+	used := map[string]int{}
+	for _, song := range songs {
+		text, offset, err := s.getSongLyricText(song.ID)
+		if err != nil {
+			return res, err
+		}
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		if offset != 0 {
+			// 将偏移写回时间轴，其他播放器不认识 LyricMapping 的偏移
+			doc := lrc.Parse(text)
+			doc.Shift(int64(offset))
+			text = lrc.Format(doc)
+		}
+
+		fileName := lrcFileName(song)
+		key := strings.ToLower(fileName)
+		used[key]++
+		if n := used[key]; n > 1 {
+			fileName = fmt.Sprintf("%s (%d).lrc", strings.TrimSuffix(fileName, ".lrc"), n)
+		}
+		if err := os.WriteFile(filepath.Join(dir, fileName), []byte(text), 0o644); err != nil {
+			return res, fmt.Errorf("写入 %s 失败: %w", fileName, err)
+		}
+		res.Written++
+	}
+	return res, nil
+}
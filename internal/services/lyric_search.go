@@ -0,0 +1,137 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"half-beat-player/internal/lyricprovider"
+	"half-beat-player/internal/models"
+	"half-beat-player/internal/textmatch"
+)
+
+// LyricProviderInfo describes a registered lyric provider and its effective config.
+type LyricProviderInfo struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	BaseURL string `json:"baseUrl"`
+}
+
+// LyricSearchResult holds ranked candidates for a song.
+type LyricSearchResult struct {
+	Query      lyricprovider.Query       `json:"query"`
+	Candidates []lyricprovider.Candidate `json:"candidates"`
+	Errors     map[string]string         `json:"errors"` // provider -> 错误信息
+}
+
+// lyricProviderOverrides reads per-provider config from the "lyricProviders" setting,
+// e.g. {"lrclib": {"enabled": true, "baseUrl": "http://127.0.0.1:8080"}}.
+func (s *Service) lyricProviderOverrides() map[string]lyricprovider.Config {
+	out := map[string]lyricprovider.Config{}
+	setting, err := s.GetPlayerSetting()
+	if err != nil {
+		return out
+	}
+	raw, ok := setting.Config["lyricProviders"]
+	if !ok {
+		return out
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return out
+	}
+	_ = json.Unmarshal(data, &out)
+	return out
+}
+
+// ListLyricProviders returns every registered provider with its effective config.
+func (s *Service) ListLyricProviders() []LyricProviderInfo {
+	overrides := s.lyricProviderOverrides()
+	names := s.lyricSrc.Names()
+
+	out := make([]LyricProviderInfo, 0, len(names))
+	for _, name := range names {
+		var override *lyricprovider.Config
+		if o, ok := overrides[name]; ok {
+			override = &o
+		}
+		cfg, _ := s.lyricSrc.Config(name, override)
+		out = append(out, LyricProviderInfo{Name: name, Enabled: cfg.Enabled, BaseURL: cfg.BaseURL})
+	}
+	return out
+}
+
+// songLyricQuery builds a provider query from a song's metadata.
+func (s *Service) songLyricQuery(song models.Song) lyricprovider.Query {
+	q := lyricprovider.Query{
+		Title:  textmatch.CleanTitle(song.Name),
+		Artist: song.Singer,
+	}
+	switch {
+	case song.SkipEndTime > song.SkipStartTime:
+		q.DurationMS = int64((song.SkipEndTime - song.SkipStartTime) * 1000)
+	case song.BVID != "":
+		// 最佳努力：从分P列表获取时长
+		if _, _, duration, err := s.getCidFromBVID(song.BVID, max(song.PageNumber, 1)); err == nil {
+			q.DurationMS = duration * 1000
+		}
+	}
+	return q
+}
+
+// FindLyrics searches all enabled providers and returns candidates ranked
+// against the song's name, singer and duration. Nothing is saved.
+func (s *Service) FindLyrics(songID string) (LyricSearchResult, error) {
+	song, err := s.getSongByID(songID)
+	if err != nil {
+		return LyricSearchResult{}, err
+	}
+	q := s.songLyricQuery(song)
+
+	providers := s.lyricSrc.Enabled(s.httpClient, s.lyricProviderOverrides())
+	if len(providers) == 0 {
+		return LyricSearchResult{}, fmt.Errorf("没有启用的歌词源")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	cands, errs := lyricprovider.SearchAll(ctx, providers, q)
+	if cands == nil {
+		cands = []lyricprovider.Candidate{}
+	}
+	return LyricSearchResult{Query: q, Candidates: cands, Errors: errs}, nil
+}
+
+// ApplyLyricCandidate fetches the chosen candidate and saves it as the song's lyric.
+func (s *Service) ApplyLyricCandidate(songID string, provider string, id string) (models.LyricMapping, error) {
+	if _, err := s.getSongByID(songID); err != nil {
+		return models.LyricMapping{}, err
+	}
+	overrides := s.lyricProviderOverrides()
+	var override *lyricprovider.Config
+	if o, ok := overrides[provider]; ok {
+		override = &o
+	}
+	p, err := s.lyricSrc.Build(provider, s.httpClient, override)
+	if err != nil {
+		return models.LyricMapping{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	text, err := p.Fetch(ctx, id)
+	if err != nil {
+		return models.LyricMapping{}, err
+	}
+
+	m, err := s.GetLyricMapping(songID)
+	if err != nil {
+		return models.LyricMapping{}, err
+	}
+	m.Lyric = text
+	if err := s.SaveLyricMapping(m); err != nil {
+		return models.LyricMapping{}, err
+	}
+	return m, nil
+}
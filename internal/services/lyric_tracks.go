@@ -0,0 +1,250 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"half-beat-player/internal/lrc"
+	"half-beat-player/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Lyric track kinds.
+const (
+	LyricKindOriginal     = "original" // 存放在 LyricMapping 中
+	LyricKindTranslation  = "translation"
+	LyricKindRomanization = "romanization"
+)
+
+// MergedLyricLine is one original line with its aligned translation and romanisation.
+type MergedLyricLine struct {
+	TimeMS       int64      `json:"timeMs"`
+	EndMS        int64      `json:"endMs"`
+	Text         string     `json:"text"`
+	Words        []lrc.Word `json:"words,omitempty"`
+	Translation  string     `json:"translation"`
+	Romanization string     `json:"romanization"`
+}
+
+// MergedLyric is the display form of a song's lyric tracks.
+type MergedLyric struct {
+	SongID           string              `json:"songId"`
+	Language         string              `json:"language"`
+	TranslationLang  string              `json:"translationLang"`
+	RomanizationLang string              `json:"romanizationLang"`
+	AvailableTracks  []models.LyricTrack `json:"availableTracks"`
+	Lines            []MergedLyricLine   `json:"lines"`
+}
+
+// ListLyricTracks returns all lyric tracks of a song; the original is
+// reported from LyricMapping with ID equal to the song id.
+func (s *Service) ListLyricTracks(songID string) ([]models.LyricTrack, error) {
+	m, err := s.GetLyricMapping(songID)
+	if err != nil {
+		return nil, err
+	}
+	out := []models.LyricTrack{}
+	if m.Lyric != "" {
+		out = append(out, models.LyricTrack{
+			ID:        m.ID,
+			SongID:    songID,
+			Kind:      LyricKindOriginal,
+			Language:  m.Language,
+			Lyric:     m.Lyric,
+			OffsetMS:  m.OffsetMS,
+			UpdatedAt: m.UpdatedAt,
+		})
+	}
+
+	var extra []models.LyricTrack
+	if err := s.db.Where("song_id = ?", songID).Order("kind, language").Find(&extra).Error; err != nil {
+		return nil, err
+	}
+	return append(out, extra...), nil
+}
+
+// SaveLyricTrack creates or updates a lyric track, keyed by song, kind and language.
+// Tracks of kind "original" are written to the song's LyricMapping.
+func (s *Service) SaveLyricTrack(track models.LyricTrack) (models.LyricTrack, error) {
+	if track.SongID == "" {
+		return track, fmt.Errorf("songID 不能为空")
+	}
+	switch track.Kind {
+	case LyricKindOriginal:
+		m, err := s.GetLyricMapping(track.SongID)
+		if err != nil {
+			return track, err
+		}
+		m.Lyric = track.Lyric
+		m.OffsetMS = track.OffsetMS
+		m.Language = track.Language
+		if err := s.SaveLyricMapping(m); err != nil {
+			return track, err
+		}
+		track.ID = m.ID
+		return track, nil
+	case LyricKindTranslation, LyricKindRomanization:
+	default:
+		return track, fmt.Errorf("未知的歌词类型: %s", track.Kind)
+	}
+
+	var existing models.LyricTrack
+	err := s.db.Where("song_id = ? AND kind = ? AND language = ?", track.SongID, track.Kind, track.Language).
+		First(&existing).Error
+	switch {
+	case err == nil:
+		track.ID = existing.ID
+		track.CreatedAt = existing.CreatedAt
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if track.ID == "" {
+			track.ID = uuid.NewString()
+		}
+	default:
+		return track, err
+	}
+	track.UpdatedAt = time.Now()
+	if err := s.db.Save(&track).Error; err != nil {
+		return track, err
+	}
+	return track, nil
+}
+
+// DeleteLyricTrack removes an additional lyric track.
+func (s *Service) DeleteLyricTrack(id string) error {
+	return s.db.Delete(&models.LyricTrack{}, "id = ?", id).Error
+}
+
+// findLyricTrack returns the first track of kind (optionally of lang) for a song.
+func findLyricTrack(tracks []models.LyricTrack, kind, lang string) *models.LyricTrack {
+	for i := range tracks {
+		if tracks[i].Kind == kind && (lang == "" || tracks[i].Language == lang) {
+			return &tracks[i]
+		}
+	}
+	return nil
+}
+
+// GetMergedLyric aligns the original lyric with a translation and a
+// romanisation track by timestamp. Empty langs pick the first track of each kind.
+func (s *Service) GetMergedLyric(songID string, translationLang string, romanizationLang string) (MergedLyric, error) {
+	doc, err := s.GetParsedLyric(songID)
+	if err != nil {
+		return MergedLyric{}, err
+	}
+	tracks, err := s.ListLyricTracks(songID)
+	if err != nil {
+		return MergedLyric{}, err
+	}
+	m, err := s.GetLyricMapping(songID)
+	if err != nil {
+		return MergedLyric{}, err
+	}
+
+	out := MergedLyric{SongID: songID, Language: m.Language, AvailableTracks: tracks, Lines: make([]MergedLyricLine, 0, len(doc.Lines))}
+	for _, l := range doc.Lines {
+		out.Lines = append(out.Lines, MergedLyricLine{TimeMS: l.TimeMS, EndMS: l.EndMS, Text: l.Text, Words: l.Words})
+	}
+
+	// 附加轨道的偏移相对原文，整体偏移沿用 LyricMapping.OffsetMS
+	alignTrack := func(t *models.LyricTrack) []string {
+		other := lrc.Parse(t.Lyric)
+		if shift := int64(t.OffsetMS + m.OffsetMS); shift != 0 {
+			other.Shift(shift)
+		}
+		return lrc.Align(doc, other, lrc.DefaultAlignToleranceMS)
+	}
+	if t := findLyricTrack(tracks, LyricKindTranslation, translationLang); t != nil {
+		out.TranslationLang = t.Language
+		for i, text := range alignTrack(t) {
+			out.Lines[i].Translation = text
+		}
+	}
+	if t := findLyricTrack(tracks, LyricKindRomanization, romanizationLang); t != nil {
+		out.RomanizationLang = t.Language
+		for i, text := range alignTrack(t) {
+			out.Lines[i].Romanization = text
+		}
+	}
+	return out, nil
+}
+
+// ImportBilingualLyric imports an LRC that carries a translation line under
+// each original timestamp, storing the original and the translation separately.
+func (s *Service) ImportBilingualLyric(songID string, text string, originalLang string, translationLang string) error {
+	orig, trans := lrc.SplitBilingual(lrc.Parse(text))
+	if len(orig.Lines) == 0 {
+		return fmt.Errorf("歌词为空")
+	}
+	m, err := s.GetLyricMapping(songID)
+	if err != nil {
+		return err
+	}
+	if _, err := s.SaveLyricTrack(models.LyricTrack{
+		SongID:   songID,
+		Kind:     LyricKindOriginal,
+		Language: originalLang,
+		Lyric:    lrc.Format(orig),
+		OffsetMS: m.OffsetMS,
+	}); err != nil {
+		return err
+	}
+	if len(trans.Lines) == 0 {
+		return nil
+	}
+	_, err = s.SaveLyricTrack(models.LyricTrack{
+		SongID:   songID,
+		Kind:     LyricKindTranslation,
+		Language: translationLang,
+		Lyric:    lrc.Format(trans),
+	})
+	return err
+}
+
+// ExportBilingualLyric renders the original lyric with the given track's lines
+// under the same timestamps ("translation in a second line" convention).
+func (s *Service) ExportBilingualLyric(songID string, kind string, lang string) (string, error) {
+	tracks, err := s.ListLyricTracks(songID)
+	if err != nil {
+		return "", err
+	}
+	orig := findLyricTrack(tracks, LyricKindOriginal, "")
+	if orig == nil {
+		return "", fmt.Errorf("歌曲没有原文歌词")
+	}
+	other := findLyricTrack(tracks, kind, lang)
+	if other == nil {
+		return "", fmt.Errorf("未找到 %s 歌词", kind)
+	}
+
+	// 附加轨道的偏移相对原文，两者都要加上原文自身的偏移
+	base := lrc.Parse(orig.Lyric)
+	if orig.OffsetMS != 0 {
+		base.Shift(int64(orig.OffsetMS))
+	}
+	extra := lrc.Parse(other.Lyric)
+	if shift := int64(orig.OffsetMS + other.OffsetMS); shift != 0 {
+		extra.Shift(shift)
+	}
+	return lrc.Format(lrc.JoinBilingual(base, extra)), nil
+}
+
+// ExportLyricTrack returns a single track as a standalone LRC, e.g. the
+// translation to be saved next to the original as a second .lrc file.
+func (s *Service) ExportLyricTrack(songID string, kind string, lang string) (string, error) {
+	tracks, err := s.ListLyricTracks(songID)
+	if err != nil {
+		return "", err
+	}
+	t := findLyricTrack(tracks, kind, lang)
+	if t == nil {
+		return "", fmt.Errorf("未找到 %s 歌词", kind)
+	}
+	doc := lrc.Parse(t.Lyric)
+	if t.OffsetMS != 0 {
+		doc.Shift(int64(t.OffsetMS))
+	}
+	return lrc.Format(doc), nil
+}
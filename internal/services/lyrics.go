@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"half-beat-player/internal/lrc"
 	"half-beat-player/internal/models"
 
 	"gorm.io/gorm"
@@ -30,3 +31,77 @@ func (s *Service) GetLyricMapping(id string) (models.LyricMapping, error) {
 	}
 	return m, nil
 }
+
+// getSongLyricText returns the lyric mapping text of a song, falling back to Song.Lyric.
+func (s *Service) getSongLyricText(songID string) (string, int, error) {
+	m, err := s.GetLyricMapping(songID)
+	if err != nil {
+		return "", 0, err
+	}
+	if m.Lyric != "" {
+		return m.Lyric, m.OffsetMS, nil
+	}
+
+	var song models.Song
+	if err := s.db.Select("lyric", "lyric_offset").First(&song, "id = ?", songID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", m.OffsetMS, nil
+		}
+		return "", 0, err
+	}
+	offset := m.OffsetMS
+	if offset == 0 {
+		offset = song.LyricOffset
+	}
+	return song.Lyric, offset, nil
+}
+
+// GetParsedLyric returns the song's lyric parsed into typed lines.
+// Times already include the [offset:] tag and the mapping's OffsetMS
+// (positive OffsetMS delays the lyrics).
+func (s *Service) GetParsedLyric(songID string) (lrc.Document, error) {
+	if songID == "" {
+		return lrc.Document{}, fmt.Errorf("songID 不能为空")
+	}
+	text, offset, err := s.getSongLyricText(songID)
+	if err != nil {
+		return lrc.Document{}, err
+	}
+	doc := lrc.Parse(text)
+	if offset != 0 {
+		doc.Shift(int64(offset))
+	}
+	return doc, nil
+}
+
+// ValidateLyric parses lyric text and returns the errors found, by line.
+func (s *Service) ValidateLyric(text string) []lrc.ParseError {
+	return lrc.Parse(text).Errors
+}
+
+// NormalizedLyric is clean LRC text plus the problems found while parsing the input.
+type NormalizedLyric struct {
+	LRC    string           `json:"lrc"`
+	Errors []lrc.ParseError `json:"errors"`
+}
+
+// NormalizeLyric parses lyric text and returns it as clean LRC.
+func (s *Service) NormalizeLyric(text string) NormalizedLyric {
+	doc := lrc.Parse(text)
+	return NormalizedLyric{LRC: lrc.Format(doc), Errors: doc.Errors}
+}
+
+// SaveParsedLyric serialises an edited lyric document (as returned by
+// GetParsedLyric) back to LRC and stores it for the song. The document's
+// times already include the offset, so they are stored as edited and the
+// mapping's offset is reset.
+func (s *Service) SaveParsedLyric(songID string, doc lrc.Document) error {
+	m, err := s.GetLyricMapping(songID)
+	if err != nil {
+		return err
+	}
+	m.Lyric = lrc.Format(doc)
+	// 时间已包含偏移；若再反向平移，被截到 0 的行会丢失原时间，因此直接清零偏移
+	m.OffsetMS = 0
+	return s.SaveLyricMapping(m)
+}
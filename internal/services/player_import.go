@@ -0,0 +1,430 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"half-beat-player/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Sources of playlist exports read by ImportPlayerExport.
+const (
+	// Azusa Player 浏览器插件与 Azusa Player Mobile 的导出
+	PlayerSourceAzusa = "azusa"
+	// 其他播放器导出的、带 bvid 字段的 JSON 歌单
+	PlayerSourceGeneric = "generic"
+)
+
+// PlayerImportRequest imports the playlist export of another Bilibili player.
+// Either Text or Path must be set.
+type PlayerImportRequest struct {
+	Text string `json:"text"`
+	Path string `json:"path"`
+	// FavoriteID 不为空时所有歌单都导入到这个歌单，否则每个歌单新建一个
+	FavoriteID string `json:"favoriteId"`
+}
+
+// PlayerSongEntry is one song of an exported playlist.
+type PlayerSongEntry struct {
+	BVID   string `json:"bvid"`
+	Page   int    `json:"page"` // 0 表示未给出
+	Cid    int64  `json:"cid"`
+	Name   string `json:"name"` // 播放器里显示的歌名
+	Singer string `json:"singer"`
+	Reason string `json:"reason,omitempty"` // 无法映射的原因
+
+	singerID    string
+	lyricOffset int
+}
+
+// PlayerPlaylist is one playlist of an export.
+type PlayerPlaylist struct {
+	Title string            `json:"title"`
+	Songs []PlayerSongEntry `json:"songs"`
+}
+
+// PlayerExport is a parsed export, for preview before importing.
+type PlayerExport struct {
+	Source    string           `json:"source"`
+	Playlists []PlayerPlaylist `json:"playlists"`
+}
+
+// PlayerPlaylistImportResult reports how one playlist was imported.
+type PlayerPlaylistImportResult struct {
+	Title      string            `json:"title"`
+	FavoriteID string            `json:"favoriteId"`
+	Total      int               `json:"total"`
+	Mapped     int               `json:"mapped"`  // 成功映射为歌曲的条目数
+	Created    int               `json:"created"` // 其中新建的歌曲数
+	Added      int               `json:"added"`   // 新加入歌单的歌曲数
+	Renamed    int               `json:"renamed"` // 改用导出歌名的已有歌曲数
+	Unmapped   []PlayerSongEntry `json:"unmapped"`
+	// 已有歌曲被改过名且与导出的歌名不同，保留本地歌名，仅列出供用户确认
+	NameDiffs []PlayerNameDiff `json:"nameDiffs"`
+}
+
+// PlayerNameDiff is a library song whose name differs from the export.
+type PlayerNameDiff struct {
+	SongID       string `json:"songId"`
+	Name         string `json:"name"`
+	ExportName   string `json:"exportName"`
+	Singer       string `json:"singer"`
+	ExportSinger string `json:"exportSinger"`
+}
+
+// PlayerImportResult reports what ImportPlayerExport did.
+type PlayerImportResult struct {
+	Source    string                       `json:"source"`
+	Total     int                          `json:"total"`
+	Mapped    int                          `json:"mapped"`
+	Playlists []PlayerPlaylistImportResult `json:"playlists"`
+}
+
+// ParsePlayerExport reads an export without importing it.
+func (s *Service) ParsePlayerExport(req PlayerImportRequest) (PlayerExport, error) {
+	text := req.Text
+	if text == "" && req.Path != "" {
+		data, err := os.ReadFile(req.Path)
+		if err != nil {
+			return PlayerExport{}, fmt.Errorf("读取导出文件失败: %w", err)
+		}
+		text = string(data)
+	}
+	raw := json.RawMessage(strings.TrimSpace(strings.TrimPrefix(text, "\ufeff")))
+	if len(raw) == 0 {
+		return PlayerExport{}, fmt.Errorf("缺少导出内容")
+	}
+	if !json.Valid(raw) {
+		return PlayerExport{}, fmt.Errorf("导出文件不是有效的 JSON")
+	}
+
+	out := PlayerExport{Source: PlayerSourceAzusa, Playlists: parseAzusaExport(raw)}
+	if len(out.Playlists) == 0 {
+		title := ""
+		if req.Path != "" {
+			title = strings.TrimSuffix(filepath.Base(req.Path), filepath.Ext(req.Path))
+		}
+		out.Source = PlayerSourceGeneric
+		collectGenericPlaylists(raw, title, &out.Playlists)
+	}
+	if len(out.Playlists) == 0 {
+		return out, fmt.Errorf("没有找到歌单，无法识别的导出格式")
+	}
+	return out, nil
+}
+
+// rawObject is a JSON object whose fields are decoded on demand, since
+// players disagree on field names and on numbers versus strings.
+type rawObject map[string]json.RawMessage
+
+// str returns the first of keys holding a string or a number.
+func (o rawObject) str(keys ...string) string {
+	for _, k := range keys {
+		v, ok := o[k]
+		if !ok {
+			continue
+		}
+		var str string
+		if err := json.Unmarshal(v, &str); err == nil && str != "" {
+			return strings.TrimSpace(str)
+		}
+		var num json.Number
+		if err := json.Unmarshal(v, &num); err == nil {
+			return num.String()
+		}
+	}
+	return ""
+}
+
+func (o rawObject) int(keys ...string) int64 {
+	for _, k := range keys {
+		if n, err := strconv.ParseFloat(o.str(k), 64); err == nil {
+			return int64(n)
+		}
+	}
+	return 0
+}
+
+// jsonObjectKeys returns the keys of a JSON object in document order.
+func jsonObjectKeys(raw json.RawMessage) []string {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return keys
+		}
+		key, _ := tok.(string)
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return keys
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// parseAzusaExport reads an Azusa Player storage dump: MyFavList lists the
+// playlist keys in order, and every playlist is {info: {title}, songList}
+// (Azusa Player Mobile puts title next to songList). Songs carry the cid as
+// id and the display name as name.
+func parseAzusaExport(raw json.RawMessage) []PlayerPlaylist {
+	var top rawObject
+	if err := json.Unmarshal(raw, &top); err != nil {
+		return nil
+	}
+	var order []string
+	if v, ok := top["MyFavList"]; ok {
+		_ = json.Unmarshal(v, &order)
+	}
+	if len(order) == 0 {
+		order = jsonObjectKeys(raw)
+	}
+
+	var out []PlayerPlaylist
+	for _, key := range order {
+		var list rawObject
+		if err := json.Unmarshal(top[key], &list); err != nil {
+			continue
+		}
+		var songs []rawObject
+		if err := json.Unmarshal(list["songList"], &songs); err != nil {
+			continue
+		}
+		var info rawObject
+		_ = json.Unmarshal(list["info"], &info)
+		pl := PlayerPlaylist{Title: info.str("title"), Songs: []PlayerSongEntry{}}
+		if pl.Title == "" {
+			pl.Title = list.str("title", "name")
+		}
+		if pl.Title == "" {
+			pl.Title = key
+		}
+		for _, song := range songs {
+			entry := playerSongEntry(song)
+			if entry.Cid == 0 {
+				entry.Cid = song.int("id")
+			}
+			pl.Songs = append(pl.Songs, entry)
+		}
+		out = append(out, pl)
+	}
+	return out
+}
+
+// collectGenericPlaylists walks any JSON document and treats every array of
+// objects with a BV number as a playlist, named after the enclosing object's
+// title or the key holding it.
+func collectGenericPlaylists(raw json.RawMessage, title string, out *[]PlayerPlaylist) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err == nil {
+		pl := PlayerPlaylist{Title: title, Songs: []PlayerSongEntry{}}
+		for _, item := range items {
+			var obj rawObject
+			if json.Unmarshal(item, &obj) != nil {
+				continue
+			}
+			if entry := playerSongEntry(obj); entry.BVID != "" {
+				pl.Songs = append(pl.Songs, entry)
+			}
+		}
+		if len(pl.Songs) > 0 {
+			if pl.Title == "" {
+				pl.Title = fmt.Sprintf("导入的歌单 %d", len(*out)+1)
+			}
+			*out = append(*out, pl)
+			return
+		}
+		for _, item := range items {
+			collectGenericPlaylists(item, "", out)
+		}
+		return
+	}
+
+	var obj rawObject
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return
+	}
+	own := obj.str("title", "name", "playlistName")
+	for _, key := range jsonObjectKeys(raw) {
+		t := own
+		if t == "" {
+			t = key
+		}
+		collectGenericPlaylists(obj[key], t, out)
+	}
+}
+
+// playerSongEntry picks the song fields out of an exported object.
+func playerSongEntry(obj rawObject) PlayerSongEntry {
+	return PlayerSongEntry{
+		BVID:        extractBVID(obj.str("bvid", "bvId", "bv_id", "bv", "BVID", "url", "link")),
+		Page:        int(obj.int("page", "p", "pageNumber", "page_number")),
+		Cid:         obj.int("cid"),
+		Name:        obj.str("name", "title", "customName", "displayName", "songName"),
+		Singer:      obj.str("singer", "artist", "author", "up", "uploader"),
+		singerID:    obj.str("singerId", "mid", "upMid"),
+		lyricOffset: int(obj.int("lyricOffset")),
+	}
+}
+
+// ImportPlayerExport imports the playlists of an Azusa Player or other
+// Bilibili player export, preserving their order. Entries are matched to
+// library songs by BV number and page (a cid is mapped to its page); new
+// songs keep the display name from the export. Entries that cannot be mapped
+// are listed per playlist.
+func (s *Service) ImportPlayerExport(req PlayerImportRequest) (PlayerImportResult, error) {
+	export, err := s.ParsePlayerExport(req)
+	if err != nil {
+		return PlayerImportResult{}, err
+	}
+	result := PlayerImportResult{Source: export.Source, Playlists: []PlayerPlaylistImportResult{}}
+
+	var library []models.Song
+	if err := s.db.Order("created_at").Find(&library).Error; err != nil {
+		return result, err
+	}
+	infos := map[string]*models.CompleteVideoInfo{}
+	songsByList := make([][]models.Song, len(export.Playlists))
+	renamed := map[string]models.Song{}
+	for i, pl := range export.Playlists {
+		plResult := PlayerPlaylistImportResult{
+			Title: pl.Title, FavoriteID: req.FavoriteID, Total: len(pl.Songs),
+			Unmapped: []PlayerSongEntry{}, NameDiffs: []PlayerNameDiff{},
+		}
+		for _, entry := range pl.Songs {
+			song, isNew, err := s.resolvePlayerSong(entry, library, infos)
+			if err != nil {
+				entry.Reason = err.Error()
+				plResult.Unmapped = append(plResult.Unmapped, entry)
+				continue
+			}
+			if isNew {
+				plResult.Created++
+				library = append(library, song)
+			} else if entry.Name != "" && entry.Name != song.Name {
+				if hasGeneratedSongName(song) {
+					// 库里还是自动生成的歌名，沿用导出里的自定义歌名
+					song.Name = entry.Name
+					if entry.Singer != "" {
+						song.Singer = entry.Singer
+					}
+					renamed[song.ID] = song
+					for j := range library {
+						if library[j].ID == song.ID {
+							library[j] = song
+						}
+					}
+					plResult.Renamed++
+				} else {
+					plResult.NameDiffs = append(plResult.NameDiffs, PlayerNameDiff{
+						SongID: song.ID, Name: song.Name, ExportName: entry.Name,
+						Singer: song.Singer, ExportSinger: entry.Singer,
+					})
+				}
+			}
+			plResult.Mapped++
+			songsByList[i] = append(songsByList[i], song)
+		}
+		result.Total += plResult.Total
+		result.Mapped += plResult.Mapped
+		result.Playlists = append(result.Playlists, plResult)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if req.FavoriteID != "" {
+			if err := ensureEditableFavorite(tx, req.FavoriteID); err != nil {
+				return err
+			}
+		}
+		for _, song := range renamed {
+			if err := tx.Model(&models.Song{}).Where("id = ?", song.ID).
+				Updates(map[string]any{"name": song.Name, "singer": song.Singer}).Error; err != nil {
+				return err
+			}
+		}
+		for i := range result.Playlists {
+			plResult := &result.Playlists[i]
+			if plResult.FavoriteID == "" {
+				fav := models.Favorite{ID: "FavList-" + uuid.NewString(), Title: plResult.Title}
+				if err := tx.Create(&fav).Error; err != nil {
+					return err
+				}
+				plResult.FavoriteID = fav.ID
+			}
+			var err error
+			if plResult.Added, err = addNewSongsToFavorite(tx, plResult.FavoriteID, songsByList[i]); err != nil {
+				return err
+			}
+			if err := touchFavorite(tx, plResult.FavoriteID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return result, err
+}
+
+// hasGeneratedSongName reports whether a song still has the name it was
+// created with from the video title, i.e. the user never renamed it.
+func hasGeneratedSongName(song models.Song) bool {
+	return song.Name == "" || song.Name == song.VideoTitle ||
+		song.Name == formatSongName(song.VideoTitle, song.PageNumber, song.PageTitle, song.TotalPages)
+}
+
+// resolvePlayerSong maps an exported song onto a library song, or builds a
+// new one named as in the export.
+func (s *Service) resolvePlayerSong(entry PlayerSongEntry, library []models.Song, infos map[string]*models.CompleteVideoInfo) (models.Song, bool, error) {
+	if entry.BVID == "" {
+		return models.Song{}, false, fmt.Errorf("缺少 BV 号")
+	}
+	page := entry.Page
+	if page <= 0 && entry.Cid > 0 {
+		info := s.cachedVideoInfo(infos, entry.BVID)
+		if info == nil {
+			return models.Song{}, false, fmt.Errorf("获取视频信息失败")
+		}
+		for _, p := range info.Pages {
+			if p.Cid == entry.Cid {
+				page = p.Page
+				break
+			}
+		}
+		if page <= 0 {
+			return models.Song{}, false, fmt.Errorf("视频中没有 cid %d", entry.Cid)
+		}
+	}
+	page = max(page, 1)
+
+	if song, ok := matchPlaylistTarget(playlistTarget{BVID: entry.BVID, Page: page}, library); ok {
+		return song, false, nil
+	}
+	info := s.cachedVideoInfo(infos, entry.BVID)
+	if info == nil {
+		return models.Song{}, false, fmt.Errorf("获取视频信息失败")
+	}
+	song, ok := newPageSong(*info, page)
+	if !ok {
+		return models.Song{}, false, fmt.Errorf("视频没有第 %d P", page)
+	}
+	if entry.Name != "" {
+		song.Name = entry.Name
+	}
+	if entry.Singer != "" {
+		song.Singer = entry.Singer
+	}
+	song.SingerID = entry.singerID
+	song.LyricOffset = entry.lyricOffset
+	return song, true, nil
+}
@@ -0,0 +1,704 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"half-beat-player/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Playlist file formats.
+const (
+	PlaylistFormatM3U8 = "m3u8"
+	PlaylistFormatXSPF = "xspf"
+	PlaylistFormatJSPF = "jspf"
+)
+
+const xspfNamespace = "http://xspf.org/ns/0/"
+
+// PlaylistEntry is one track read from or written to a playlist file.
+type PlaylistEntry struct {
+	Location   string  `json:"location"`
+	Identifier string  `json:"identifier,omitempty"` // 导出时为 B 站链接，本地路径无法解析时备用
+	Title      string  `json:"title"`
+	Creator    string  `json:"creator"`
+	Duration   float64 `json:"duration"` // 秒，未知为 0
+	Image      string  `json:"image,omitempty"`
+	Reason     string  `json:"reason,omitempty"` // 导入失败原因
+}
+
+// PlaylistImportRequest imports a playlist file. Either Text or Path must be
+// set. Format is detected from Path or the content when empty. Relative local
+// paths are resolved against the directory of Path.
+type PlaylistImportRequest struct {
+	Text       string `json:"text"`
+	Path       string `json:"path"`
+	Format     string `json:"format"`
+	FavoriteID string `json:"favoriteId"` // 为空时新建歌单
+}
+
+// PlaylistImportResult reports what ImportPlaylistFile did.
+type PlaylistImportResult struct {
+	FavoriteID string          `json:"favoriteId"`
+	Title      string          `json:"title"`
+	Total      int             `json:"total"`
+	Reused     int             `json:"reused"`  // 匹配到曲库中已有歌曲的条目数
+	Created    int             `json:"created"` // 新建的歌曲数
+	Added      int             `json:"added"`   // 新加入歌单的歌曲数
+	Unresolved []PlaylistEntry `json:"unresolved"`
+}
+
+// ExportFavoritePlaylist renders a favorite as an M3U8, XSPF or JSPF playlist.
+// Songs are written as Bilibili URLs; with preferLocal, downloaded songs are
+// written as local file paths instead. Segments carry a #t=start,end media
+// fragment so they can be told apart on import.
+func (s *Service) ExportFavoritePlaylist(favoriteID string, format string, preferLocal bool) (string, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	var fav models.Favorite
+	if err := s.db.First(&fav, "id = ?", favoriteID).Error; err != nil {
+		return "", fmt.Errorf("未找到歌单: %w", err)
+	}
+	songs, err := s.getFavoriteSongs(favoriteID)
+	if err != nil {
+		return "", err
+	}
+	s.fillSongDurations(songs)
+
+	entries := make([]PlaylistEntry, 0, len(songs))
+	for _, song := range songs {
+		e := PlaylistEntry{
+			Title:    song.Name,
+			Creator:  song.Singer,
+			Duration: songDurationSeconds(song),
+			Image:    song.Cover,
+		}
+		if song.BVID != "" {
+			e.Identifier = biliSongURL(song)
+			e.Location = e.Identifier
+		}
+		if preferLocal || e.Location == "" {
+			if path := s.downloadedAudioPath(song); path != "" {
+				e.Location = path
+			}
+		}
+		if e.Location == "" {
+			// 没有 BV 号也没有本地文件的歌曲无法定位
+			continue
+		}
+		if e.Identifier == e.Location {
+			e.Identifier = ""
+		}
+		entries = append(entries, e)
+	}
+
+	switch format {
+	case PlaylistFormatM3U8, "m3u":
+		return renderM3U8(fav.Title, entries), nil
+	case PlaylistFormatXSPF:
+		return renderXSPF(fav.Title, entries)
+	case PlaylistFormatJSPF:
+		return renderJSPF(fav.Title, entries)
+	default:
+		return "", fmt.Errorf("不支持的歌单格式: %s", format)
+	}
+}
+
+// fillSongDurations looks up and stores the page duration of songs that do
+// not have one yet. Failures are ignored; those songs export as unknown.
+func (s *Service) fillSongDurations(songs []models.Song) {
+	infos := map[string]*models.CompleteVideoInfo{}
+	for i := range songs {
+		song := &songs[i]
+		if song.Duration > 0 || song.BVID == "" {
+			continue
+		}
+		info := s.cachedVideoInfo(infos, song.BVID)
+		if info == nil {
+			continue
+		}
+		for _, page := range info.Pages {
+			if page.Page == max(song.PageNumber, 1) && page.Duration > 0 {
+				song.Duration = page.Duration
+				s.db.Model(&models.Song{}).Where("id = ?", song.ID).UpdateColumn("duration", page.Duration)
+				break
+			}
+		}
+	}
+}
+
+// biliSongURL returns the video page URL of a song, with a media fragment for
+// segments.
+func biliSongURL(song models.Song) string {
+	u := fmt.Sprintf("https://www.bilibili.com/video/%s?p=%d", song.BVID, max(song.PageNumber, 1))
+	if isSegmentSong(song) {
+		u += "#t=" + formatPlaylistSeconds(song.SkipStartTime)
+		if end := segmentEnd(song); end > 0 {
+			u += "," + formatPlaylistSeconds(end)
+		}
+	}
+	return u
+}
+
+func formatPlaylistSeconds(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1000)/1000, 'f', -1, 64)
+}
+
+// downloadedAudioPath returns the file of a song in the downloads directory,
+// or "" if it has not been downloaded.
+func (s *Service) downloadedAudioPath(song models.Song) string {
+	names := []string{}
+	if name := localProxyFileName(song.StreamURL); name != "" {
+		names = append(names, name)
+	}
+	if name := s.getLocalAudioFilename(song); name != "" {
+		names = append(names, name)
+	}
+	for _, name := range names {
+		path := filepath.Join(s.dataDir, downloadsDir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// localProxyFileName returns the f parameter of a local proxy URL.
+func localProxyFileName(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() != "127.0.0.1" || u.Path != "/local" {
+		return ""
+	}
+	return u.Query().Get("f")
+}
+
+func renderM3U8(title string, entries []PlaylistEntry) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	if title != "" {
+		fmt.Fprintf(&b, "#PLAYLIST:%s\n", oneLine(title))
+	}
+	for _, e := range entries {
+		dur := -1
+		if e.Duration > 0 {
+			dur = int(math.Round(e.Duration))
+		}
+		name := e.Title
+		if e.Creator != "" {
+			name = e.Creator + " - " + e.Title
+		}
+		fmt.Fprintf(&b, "#EXTINF:%d,%s\n", dur, oneLine(name))
+		b.WriteString(e.Location)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// playlistURI turns a local path into a file URI; URLs are returned as is.
+func playlistURI(location string) string {
+	if strings.Contains(location, "://") {
+		return location
+	}
+	path := filepath.ToSlash(location)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path // Windows 盘符
+	}
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Version string      `xml:"version,attr"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location   []string `xml:"location"`
+	Identifier []string `xml:"identifier,omitempty"`
+	Title      string   `xml:"title,omitempty"`
+	Creator    string   `xml:"creator,omitempty"`
+	Duration   int64    `xml:"duration,omitempty"` // 毫秒
+	Image      string   `xml:"image,omitempty"`
+}
+
+func renderXSPF(title string, entries []PlaylistEntry) (string, error) {
+	pl := xspfPlaylist{Version: "1", XMLNS: xspfNamespace, Title: title}
+	for _, e := range entries {
+		t := xspfTrack{
+			Location: []string{playlistURI(e.Location)},
+			Title:    e.Title,
+			Creator:  e.Creator,
+			Duration: int64(math.Round(e.Duration * 1000)),
+			Image:    e.Image,
+		}
+		if e.Identifier != "" {
+			t.Identifier = []string{e.Identifier}
+		}
+		pl.Tracks = append(pl.Tracks, t)
+	}
+	data, err := xml.MarshalIndent(pl, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(data) + "\n", nil
+}
+
+type jspfDocument struct {
+	Playlist struct {
+		Title string      `json:"title,omitempty"`
+		Track []jspfTrack `json:"track"`
+	} `json:"playlist"`
+}
+
+type jspfTrack struct {
+	Location   jspfStrings `json:"location,omitempty"`
+	Identifier jspfStrings `json:"identifier,omitempty"`
+	Title      string      `json:"title,omitempty"`
+	Creator    string      `json:"creator,omitempty"`
+	Duration   int64       `json:"duration,omitempty"` // 毫秒
+	Image      string      `json:"image,omitempty"`
+}
+
+// jspfStrings accepts both a string and an array of strings; some writers
+// emit a bare string where the spec wants an array.
+type jspfStrings []string
+
+func (v *jspfStrings) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*v = jspfStrings{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*v = many
+	return nil
+}
+
+func renderJSPF(title string, entries []PlaylistEntry) (string, error) {
+	var doc jspfDocument
+	doc.Playlist.Title = title
+	doc.Playlist.Track = []jspfTrack{}
+	for _, e := range entries {
+		t := jspfTrack{
+			Location: jspfStrings{playlistURI(e.Location)},
+			Title:    e.Title,
+			Creator:  e.Creator,
+			Duration: int64(math.Round(e.Duration * 1000)),
+			Image:    e.Image,
+		}
+		if e.Identifier != "" {
+			t.Identifier = jspfStrings{e.Identifier}
+		}
+		doc.Playlist.Track = append(doc.Playlist.Track, t)
+	}
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
+
+// parsePlaylist detects the format when empty and returns the playlist title
+// and its entries. Every entry has at least one location, the first in
+// Location and a fallback in Identifier.
+func parsePlaylist(text, format string) (string, []PlaylistEntry, error) {
+	text = strings.TrimPrefix(text, "\ufeff")
+	format = strings.ToLower(format)
+	if format == "" {
+		switch trimmed := strings.TrimSpace(text); {
+		case strings.HasPrefix(trimmed, "<"):
+			format = PlaylistFormatXSPF
+		case strings.HasPrefix(trimmed, "{"):
+			format = PlaylistFormatJSPF
+		default:
+			format = PlaylistFormatM3U8
+		}
+	}
+	switch format {
+	case PlaylistFormatM3U8, "m3u":
+		title, entries := parseM3U(text)
+		return title, entries, nil
+	case PlaylistFormatXSPF:
+		return parseXSPF(text)
+	case PlaylistFormatJSPF, "json":
+		return parseJSPF(text)
+	default:
+		return "", nil, fmt.Errorf("不支持的歌单格式: %s", format)
+	}
+}
+
+func parseM3U(text string) (string, []PlaylistEntry) {
+	var title string
+	var entries []PlaylistEntry
+	var pending PlaylistEntry
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			info := strings.TrimPrefix(line, "#EXTINF:")
+			durPart, name, _ := strings.Cut(info, ",")
+			// 时长后面可能跟着 tvg-id="..." 之类的属性
+			if fields := strings.Fields(durPart); len(fields) > 0 {
+				if d, err := strconv.ParseFloat(fields[0], 64); err == nil && d > 0 {
+					pending.Duration = d
+				}
+			}
+			pending.Title = strings.TrimSpace(name)
+			if creator, t, ok := strings.Cut(pending.Title, " - "); ok {
+				pending.Creator, pending.Title = strings.TrimSpace(creator), strings.TrimSpace(t)
+			}
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			title = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#"):
+		default:
+			pending.Location = line
+			entries = append(entries, pending)
+			pending = PlaylistEntry{}
+		}
+	}
+	return title, entries
+}
+
+func parseXSPF(text string) (string, []PlaylistEntry, error) {
+	var pl xspfPlaylist
+	if err := xml.Unmarshal([]byte(text), &pl); err != nil {
+		return "", nil, fmt.Errorf("解析 XSPF 失败: %w", err)
+	}
+	var entries []PlaylistEntry
+	for _, t := range pl.Tracks {
+		if e, ok := playlistEntryFrom(t.Location, t.Identifier, t.Title, t.Creator, t.Duration, t.Image); ok {
+			entries = append(entries, e)
+		}
+	}
+	return pl.Title, entries, nil
+}
+
+func parseJSPF(text string) (string, []PlaylistEntry, error) {
+	var doc jspfDocument
+	if err := json.Unmarshal([]byte(text), &doc); err != nil {
+		return "", nil, fmt.Errorf("解析 JSPF 失败: %w", err)
+	}
+	var entries []PlaylistEntry
+	for _, t := range doc.Playlist.Track {
+		if e, ok := playlistEntryFrom(t.Location, t.Identifier, t.Title, t.Creator, t.Duration, t.Image); ok {
+			entries = append(entries, e)
+		}
+	}
+	return doc.Playlist.Title, entries, nil
+}
+
+func playlistEntryFrom(locations, identifiers []string, title, creator string, durationMS int64, image string) (PlaylistEntry, bool) {
+	e := PlaylistEntry{Title: title, Creator: creator, Duration: float64(durationMS) / 1000, Image: image}
+	for _, loc := range append(locations, identifiers...) {
+		loc = strings.TrimSpace(loc)
+		switch {
+		case loc == "":
+		case e.Location == "":
+			e.Location = loc
+		case e.Identifier == "":
+			e.Identifier = loc
+		}
+	}
+	return e, e.Location != ""
+}
+
+// playlistTarget is what a playlist location points at: a Bilibili page
+// (optionally a segment of it) or a local file.
+type playlistTarget struct {
+	BVID      string
+	Page      int
+	Start     float64
+	End       float64
+	Segment   bool
+	LocalPath string
+}
+
+// parsePlaylistLocation recognises bilibili.com and b23.tv URLs, file URIs
+// and plain paths. b23.tv short links are resolved over the network.
+func (s *Service) parsePlaylistLocation(loc, baseDir string) (playlistTarget, error) {
+	lower := strings.ToLower(loc)
+	if strings.HasPrefix(lower, "file:") {
+		u, err := url.Parse(loc)
+		if err != nil {
+			return playlistTarget{}, fmt.Errorf("无效的文件地址")
+		}
+		path := u.Path
+		// file:///C:/x → C:/x
+		if len(path) > 2 && path[0] == '/' && path[2] == ':' {
+			path = path[1:]
+		}
+		return playlistTarget{LocalPath: filepath.FromSlash(path)}, nil
+	}
+	if !strings.Contains(lower, "://") && !strings.Contains(lower, "bilibili.com") && !strings.Contains(lower, "b23.tv") {
+		path := filepath.FromSlash(loc)
+		if !filepath.IsAbs(path) && baseDir != "" {
+			path = filepath.Join(baseDir, path)
+		}
+		return playlistTarget{LocalPath: path}, nil
+	}
+
+	if !strings.Contains(lower, "://") {
+		loc = "https://" + loc
+	}
+	u, err := url.Parse(loc)
+	if err != nil {
+		return playlistTarget{}, fmt.Errorf("无效的链接")
+	}
+	if strings.HasSuffix(strings.ToLower(u.Hostname()), "b23.tv") {
+		if u, err = s.resolveShortLink(u.String()); err != nil {
+			return playlistTarget{}, err
+		}
+	}
+	bvid := extractBVID(u.Path)
+	if bvid == "" || !strings.HasSuffix(strings.ToLower(u.Hostname()), "bilibili.com") {
+		return playlistTarget{}, fmt.Errorf("不是 B 站视频链接")
+	}
+	t := playlistTarget{BVID: bvid, Page: 1}
+	if p, err := strconv.Atoi(u.Query().Get("p")); err == nil && p > 0 {
+		t.Page = p
+	}
+	// 媒体片段 #t=start,end，表示视频中的一段
+	frag := u.Fragment
+	if strings.HasPrefix(frag, "t=") {
+		start, end, _ := strings.Cut(strings.TrimPrefix(frag, "t="), ",")
+		t.Start, _ = strconv.ParseFloat(start, 64)
+		t.End, _ = strconv.ParseFloat(end, 64)
+		t.Segment = t.Start > 0 || t.End > 0
+	}
+	return t, nil
+}
+
+// resolveShortLink follows a b23.tv redirect and returns the final URL.
+func (s *Service) resolveShortLink(raw string) (*url.URL, error) {
+	resp, err := s.httpClient.Get(raw)
+	if err != nil {
+		return nil, fmt.Errorf("解析短链接失败: %w", err)
+	}
+	resp.Body.Close()
+	return resp.Request.URL, nil
+}
+
+// ImportPlaylistFile reads an M3U8, XSPF or JSPF playlist and adds its
+// entries to a favorite in order. Bilibili URLs become songs for that page
+// (reusing library songs); local paths are matched against downloaded songs.
+// Entries that cannot be resolved are listed in the result.
+func (s *Service) ImportPlaylistFile(req PlaylistImportRequest) (PlaylistImportResult, error) {
+	result := PlaylistImportResult{FavoriteID: req.FavoriteID, Unresolved: []PlaylistEntry{}}
+	text, baseDir := req.Text, ""
+	if req.Path != "" {
+		baseDir = filepath.Dir(req.Path)
+		if text == "" {
+			data, err := os.ReadFile(req.Path)
+			if err != nil {
+				return result, fmt.Errorf("读取歌单文件失败: %w", err)
+			}
+			text = string(data)
+		}
+		if req.Format == "" {
+			switch ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(req.Path), ".")); ext {
+			case "m3u", PlaylistFormatM3U8, PlaylistFormatXSPF, PlaylistFormatJSPF:
+				req.Format = ext
+			}
+		}
+	}
+	if strings.TrimSpace(text) == "" {
+		return result, fmt.Errorf("缺少歌单内容")
+	}
+	title, entries, err := parsePlaylist(text, req.Format)
+	if err != nil {
+		return result, err
+	}
+	if title == "" && req.Path != "" {
+		title = strings.TrimSuffix(filepath.Base(req.Path), filepath.Ext(req.Path))
+	}
+	result.Title = title
+	result.Total = len(entries)
+
+	var library []models.Song
+	if err := s.db.Order("created_at").Find(&library).Error; err != nil {
+		return result, err
+	}
+	infos := map[string]*models.CompleteVideoInfo{}
+	songs := make([]models.Song, 0, len(entries))
+	for _, e := range entries {
+		song, isNew, err := s.resolvePlaylistEntry(e, baseDir, library, infos)
+		if err != nil {
+			e.Reason = err.Error()
+			result.Unresolved = append(result.Unresolved, e)
+			continue
+		}
+		if isNew {
+			result.Created++
+			library = append(library, song)
+		} else {
+			result.Reused++
+		}
+		songs = append(songs, song)
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if result.FavoriteID == "" {
+			name := title
+			if name == "" {
+				name = "导入的歌单"
+			}
+			fav := models.Favorite{ID: "FavList-" + uuid.NewString(), Title: name}
+			if err := tx.Create(&fav).Error; err != nil {
+				return err
+			}
+			result.FavoriteID = fav.ID
+		} else if err := ensureEditableFavorite(tx, result.FavoriteID); err != nil {
+			return err
+		}
+		var err error
+		if result.Added, err = addNewSongsToFavorite(tx, result.FavoriteID, songs); err != nil {
+			return err
+		}
+		return touchFavorite(tx, result.FavoriteID)
+	})
+	return result, err
+}
+
+// resolvePlaylistEntry maps an entry onto a library song, or builds a new
+// one for a Bilibili page. The identifier is tried when the location fails.
+func (s *Service) resolvePlaylistEntry(e PlaylistEntry, baseDir string, library []models.Song, infos map[string]*models.CompleteVideoInfo) (models.Song, bool, error) {
+	song, isNew, err := s.resolvePlaylistLocation(e, e.Location, baseDir, library, infos)
+	if err != nil && e.Identifier != "" {
+		if song, isNew, err2 := s.resolvePlaylistLocation(e, e.Identifier, baseDir, library, infos); err2 == nil {
+			return song, isNew, nil
+		}
+	}
+	return song, isNew, err
+}
+
+func (s *Service) resolvePlaylistLocation(e PlaylistEntry, loc, baseDir string, library []models.Song, infos map[string]*models.CompleteVideoInfo) (models.Song, bool, error) {
+	target, err := s.parsePlaylistLocation(loc, baseDir)
+	if err != nil {
+		return models.Song{}, false, err
+	}
+
+	if target.LocalPath != "" {
+		name := filepath.Base(target.LocalPath)
+		if _, err := os.Stat(filepath.Join(s.dataDir, downloadsDir, name)); err != nil {
+			return models.Song{}, false, fmt.Errorf("本地文件不在下载目录中")
+		}
+		for _, song := range library {
+			if localProxyFileName(song.StreamURL) == name || (song.BVID != "" && s.getLocalAudioFilename(song) == name) {
+				return song, false, nil
+			}
+		}
+		return models.Song{}, false, fmt.Errorf("下载目录中的文件没有对应的歌曲")
+	}
+
+	if song, ok := matchPlaylistTarget(target, library); ok {
+		return song, false, nil
+	}
+
+	info := s.cachedVideoInfo(infos, target.BVID)
+	if info == nil {
+		return models.Song{}, false, fmt.Errorf("获取视频信息失败")
+	}
+	song, ok := newPageSong(*info, target.Page)
+	if !ok {
+		return models.Song{}, false, fmt.Errorf("视频没有第 %d P", target.Page)
+	}
+	if target.Segment {
+		song.SkipStartTime, song.SkipEndTime = target.Start, target.End
+		if e.Title != "" {
+			song.Name = e.Title
+		}
+		if e.Creator != "" {
+			song.Singer = e.Creator
+		}
+	}
+	return song, true, nil
+}
+
+// cachedVideoInfo fetches video info once per BV number; nil means the
+// lookup failed.
+func (s *Service) cachedVideoInfo(infos map[string]*models.CompleteVideoInfo, bvid string) *models.CompleteVideoInfo {
+	info, ok := infos[bvid]
+	if !ok {
+		if got, err := s.getCompleteVideoInfo(bvid); err == nil {
+			info = &got
+		}
+		infos[bvid] = info
+	}
+	return info
+}
+
+// newPageSong builds a new song for one page of a video.
+func newPageSong(info models.CompleteVideoInfo, pageNumber int) (models.Song, bool) {
+	for _, page := range info.Pages {
+		if page.Page != pageNumber {
+			continue
+		}
+		return models.Song{
+			ID:         uuid.NewString(),
+			BVID:       info.BVID,
+			Name:       formatSongName(info.Title, page.Page, page.Part, len(info.Pages)),
+			Singer:     info.Author,
+			Cover:      info.Cover,
+			PageNumber: page.Page,
+			PageTitle:  page.Part,
+			VideoTitle: info.Title,
+			TotalPages: len(info.Pages),
+			Duration:   page.Duration,
+		}, true
+	}
+	return models.Song{}, false
+}
+
+// matchPlaylistTarget finds the library song for a Bilibili page. A segment
+// matches the song with the same start and end time; a whole page prefers a
+// song that is not a segment.
+func matchPlaylistTarget(t playlistTarget, library []models.Song) (models.Song, bool) {
+	var fallback *models.Song
+	for i := range library {
+		song := &library[i]
+		if song.BVID != t.BVID || max(song.PageNumber, 1) != t.Page {
+			continue
+		}
+		isSegment := isSegmentSong(*song)
+		// 按库中歌曲的时长判断片段，结束时间落在页尾的片段视为整页
+		probe := models.Song{SkipStartTime: t.Start, SkipEndTime: t.End, Duration: song.Duration}
+		if t.Segment && isSegmentSong(probe) {
+			if isSegment && math.Abs(song.SkipStartTime-t.Start) < 0.5 && math.Abs(segmentEnd(*song)-segmentEnd(probe)) < 0.5 {
+				return *song, true
+			}
+			continue
+		}
+		if !isSegment {
+			return *song, true
+		}
+		if fallback == nil {
+			fallback = song
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return models.Song{}, false
+}
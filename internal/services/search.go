@@ -15,13 +15,30 @@ import (
 	"gorm.io/gorm"
 )
 
-// SearchLocalSongs searches songs in local database by name or singer.
+// SearchLocalSongs searches songs in local database, best matches first.
+// See SearchLibrary for what is matched; an empty keyword returns all songs.
 func (s *Service) SearchLocalSongs(keyword string) ([]models.Song, error) {
 	var songs []models.Song
-	searchTerm := "%" + keyword + "%"
-	if err := s.db.Where("name LIKE ? OR singer LIKE ?", searchTerm, searchTerm).
-		Find(&songs).Error; err != nil {
-		return nil, err
+	if strings.TrimSpace(keyword) == "" {
+		if err := s.db.Find(&songs).Error; err != nil {
+			return nil, err
+		}
+		return songs, nil
+	}
+	hits, err := s.SearchLibrary(keyword, searchMaxLimit)
+	if err != nil {
+		// 索引不可用时退回到简单的 LIKE 查询
+		fmt.Printf("[Search] 本地搜索索引不可用: %v\n", err)
+		searchTerm := "%" + keyword + "%"
+		if err := s.db.Where("name LIKE ? OR singer LIKE ?", searchTerm, searchTerm).
+			Find(&songs).Error; err != nil {
+			return nil, err
+		}
+		return songs, nil
+	}
+	songs = make([]models.Song, 0, len(hits))
+	for _, h := range hits {
+		songs = append(songs, h.Song)
 	}
 	return songs, nil
 }
@@ -36,7 +53,7 @@ func (s *Service) SearchBiliVideos(keyword string, page int, pageSize int, order
 		pageSize = 10
 	}
 	_ = s.warmupBiliCookies()
-	api := "https://api.bilibili.com/x/web-interface/search/type"
+	api := "https://api.bilibili.com/x/web-interface/wbi/search/type"
 	q := url.Values{}
 	q.Set("search_type", "video")
 	q.Set("keyword", keyword)
@@ -46,39 +63,27 @@ func (s *Service) SearchBiliVideos(keyword string, page int, pageSize int, order
 		order = "totalrank"
 	}
 	q.Set("order", order)
-	endpoint := api + "?" + q.Encode()
 
-	req, _ := http.NewRequest("GET", endpoint, nil)
-	req.Header.Set("User-Agent", "Mozilla/5.0")
-	req.Header.Set("Referer", "https://search.bilibili.com/")
-	req.Header.Set("Origin", "https://www.bilibili.com")
-	req.Header.Set("Accept", "application/json, text/plain, */*")
-	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
+	header := http.Header{}
+	header.Set("User-Agent", "Mozilla/5.0")
+	header.Set("Referer", "https://search.bilibili.com/")
+	header.Set("Origin", "https://www.bilibili.com")
+	header.Set("Accept", "application/json, text/plain, */*")
+	header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
 	if cookieHeader := s.buildBiliCookieHeader("https://api.bilibili.com"); cookieHeader != "" {
-		req.Header.Set("Cookie", cookieHeader)
+		header.Set("Cookie", cookieHeader)
 	}
-	client := s.httpClient
-	if client == nil {
-		client = http.DefaultClient
-	}
-	resp, err := client.Do(req)
+	resp, body, err := s.biliWBIGet(api, q, header)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	body, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode == http.StatusPreconditionFailed {
 		_ = s.warmupBiliCookies()
-		resp.Body.Close()
-		req2, _ := http.NewRequest("GET", endpoint, nil)
-		req2.Header = req.Header.Clone()
-		resp, err = client.Do(req2)
+		resp, body, err = s.biliWBIGet(api, q, header)
 		if err != nil {
 			return nil, err
 		}
-		defer resp.Body.Close()
-		body, _ = io.ReadAll(resp.Body)
 	}
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
@@ -0,0 +1,345 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"half-beat-player/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Segment sources accepted by GetVideoSegments.
+const (
+	SegmentSourceAuto        = ""
+	SegmentSourceChapters    = "chapters"
+	SegmentSourceDescription = "description"
+	SegmentSourceComment     = "comment"
+)
+
+// 匹配 mm:ss / hh:mm:ss（可带小数秒）
+var timestampRe = regexp.MustCompile(`(\d{1,2}:)?\d{1,2}:\d{2}(\.\d{1,3})?`)
+
+// 标题两端需要去除的分隔符
+const segmentTitleCutset = " \t-–—~～|/、，,:：.。)]）】>》"
+
+// TrackSegment is one song inside a long recording.
+type TrackSegment struct {
+	Start  float64 `json:"start"` // 秒
+	End    float64 `json:"end"`   // 秒，0 表示播放到结尾
+	Title  string  `json:"title"`
+	Singer string  `json:"singer,omitempty"` // 可选，覆盖视频作者（如 cue 的 PERFORMER）
+}
+
+// SegmentPreview lists the segments found for a video page and where they came from.
+type SegmentPreview struct {
+	BVID       string         `json:"bvid"`
+	Page       int            `json:"page"`
+	VideoTitle string         `json:"videoTitle"`
+	Duration   int64          `json:"duration"`
+	Source     string         `json:"source"`
+	Segments   []TrackSegment `json:"segments"`
+}
+
+// SplitRequest describes how to split one video page into songs.
+type SplitRequest struct {
+	BVID       string         `json:"bvid"`
+	Page       int            `json:"page"`
+	FavoriteID string         `json:"favoriteId"` // 为空则只创建歌曲
+	Segments   []TrackSegment `json:"segments"`
+}
+
+// ParseTimestampList parses a pasted track list into segments.
+func (s *Service) ParseTimestampList(text string) []TrackSegment {
+	return parseTimestampList(text)
+}
+
+// parseTimestampList parses "00:00 song A / 04:12 song B" style track lists.
+// Both one-entry-per-line and single-line lists are supported, as are ranges
+// such as "00:00-04:12 song A".
+func parseTimestampList(text string) []TrackSegment {
+	matches := timestampRe.FindAllStringIndex(text, -1)
+	var segs []TrackSegment
+	for i := 0; i < len(matches); i++ {
+		m := matches[i]
+		// 排除日期、比分等嵌在数字中的情况
+		if m[0] > 0 && strings.ContainsAny(text[m[0]-1:m[0]], "0123456789:") {
+			continue
+		}
+		if m[0] > 1 && strings.ContainsAny(text[m[0]-1:m[0]], "-/.") && strings.ContainsAny(text[m[0]-2:m[0]-1], "0123456789") {
+			continue
+		}
+		start, ok := parseClock(text[m[0]:m[1]])
+		if !ok {
+			continue
+		}
+
+		seg := TrackSegment{Start: start}
+		tail := m[1]
+		if i+1 < len(matches) {
+			between := text[m[1]:matches[i+1][0]]
+			if strings.Trim(between, segmentTitleCutset) == "" && !strings.Contains(between, "\n") {
+				// 时间区间 "00:00-04:12"
+				if end, ok := parseClock(text[matches[i+1][0]:matches[i+1][1]]); ok && end > start {
+					seg.End = end
+					tail = matches[i+1][1]
+					i++
+				}
+			}
+		}
+
+		next := len(text)
+		if i+1 < len(matches) {
+			next = matches[i+1][0]
+		}
+		title := text[tail:next]
+		if nl := strings.IndexAny(title, "\r\n"); nl >= 0 {
+			title = title[:nl]
+		}
+		seg.Title = strings.Trim(title, segmentTitleCutset)
+		segs = append(segs, seg)
+	}
+	return normaliseSegments(segs)
+}
+
+// parseClock parses mm:ss or hh:mm:ss(.fff) into seconds.
+func parseClock(s string) (float64, bool) {
+	parts := strings.Split(s, ":")
+	var total float64
+	for _, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return 0, false
+		}
+		total = total*60 + v
+	}
+	return total, true
+}
+
+// normaliseSegments sorts segments, drops duplicates and fills open ends
+// from the following segment's start.
+func normaliseSegments(segs []TrackSegment) []TrackSegment {
+	sort.SliceStable(segs, func(i, j int) bool { return segs[i].Start < segs[j].Start })
+	out := make([]TrackSegment, 0, len(segs))
+	for _, seg := range segs {
+		if len(out) > 0 && out[len(out)-1].Start == seg.Start {
+			continue
+		}
+		out = append(out, seg)
+	}
+	for i := range out {
+		if out[i].End == 0 && i+1 < len(out) {
+			out[i].End = out[i+1].Start
+		}
+	}
+	return out
+}
+
+// GetVideoSegments finds a track list for a video page.
+// source selects chapters, description or the pinned comment; empty tries them in that order.
+func (s *Service) GetVideoSegments(bvid string, page int, source string) (SegmentPreview, error) {
+	bvid = extractBVID(bvid)
+	if bvid == "" {
+		return SegmentPreview{}, fmt.Errorf("invalid BVID format")
+	}
+	if page <= 0 {
+		page = 1
+	}
+
+	info, err := s.getVideoInfo(bvid)
+	if err != nil {
+		return SegmentPreview{}, err
+	}
+	out := SegmentPreview{BVID: bvid, Page: page, VideoTitle: info.Title}
+
+	sources := []string{source}
+	if source == SegmentSourceAuto {
+		sources = []string{SegmentSourceChapters, SegmentSourceDescription, SegmentSourceComment}
+	}
+
+	for _, src := range sources {
+		var segs []TrackSegment
+		switch src {
+		case SegmentSourceChapters:
+			chapters, duration, err := s.getVideoChapters(bvid, page)
+			if err != nil {
+				if source != SegmentSourceAuto {
+					return out, err
+				}
+				continue
+			}
+			out.Duration = duration
+			for _, ch := range chapters {
+				segs = append(segs, TrackSegment{Start: float64(ch.From), End: float64(ch.To), Title: ch.Content})
+			}
+		case SegmentSourceDescription:
+			segs = parseTimestampList(info.Desc)
+		case SegmentSourceComment:
+			text, err := s.getPinnedComment(info.Aid)
+			if err != nil {
+				if source != SegmentSourceAuto {
+					return out, err
+				}
+				continue
+			}
+			segs = parseTimestampList(text)
+		default:
+			return out, fmt.Errorf("unknown segment source: %s", src)
+		}
+
+		if len(segs) > 1 {
+			out.Source = src
+			out.Segments = segs
+			break
+		}
+	}
+
+	if len(out.Segments) == 0 {
+		return out, fmt.Errorf("未找到时间戳列表")
+	}
+	// 最后一段与视频结尾重合时用 0 表示播放到结尾
+	if last := &out.Segments[len(out.Segments)-1]; out.Duration > 0 && last.End >= float64(out.Duration) {
+		last.End = 0
+	}
+	return out, nil
+}
+
+// getPinnedComment returns the uploader's pinned comment text of a video.
+func (s *Service) getPinnedComment(aid int64) (string, error) {
+	if aid == 0 {
+		return "", fmt.Errorf("缺少 aid")
+	}
+	q := url.Values{}
+	q.Set("type", "1")
+	q.Set("oid", strconv.FormatInt(aid, 10))
+	q.Set("mode", "3")
+
+	header := http.Header{}
+	header.Set("User-Agent", "Mozilla/5.0")
+	header.Set("Referer", "https://www.bilibili.com/")
+
+	_, body, err := s.biliWBIGet("https://api.bilibili.com/x/v2/reply/wbi/main", q, header)
+	if err != nil {
+		return "", fmt.Errorf("reply request error: %w", err)
+	}
+
+	type reply struct {
+		Content struct {
+			Message string `json:"message"`
+		} `json:"content"`
+	}
+	var res struct {
+		Code int    `json:"code"`
+		Msg  string `json:"message"`
+		Data struct {
+			Top struct {
+				Upper *reply `json:"upper"`
+			} `json:"top"`
+			Upper struct {
+				Top *reply `json:"top"`
+			} `json:"upper"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return "", fmt.Errorf("reply decode error: %w", err)
+	}
+	if res.Code != 0 {
+		return "", fmt.Errorf("reply API error: code=%d, msg=%s", res.Code, res.Msg)
+	}
+	if r := res.Data.Top.Upper; r != nil && r.Content.Message != "" {
+		return r.Content.Message, nil
+	}
+	if r := res.Data.Upper.Top; r != nil && r.Content.Message != "" {
+		return r.Content.Message, nil
+	}
+	return "", fmt.Errorf("视频没有置顶评论")
+}
+
+// SplitVideoIntoSongs creates one song per segment, all sharing the same stream source,
+// and appends them in order to the given favorite.
+func (s *Service) SplitVideoIntoSongs(req SplitRequest) ([]models.Song, error) {
+	bvid := extractBVID(req.BVID)
+	if bvid == "" {
+		return nil, fmt.Errorf("invalid BVID format")
+	}
+	if len(req.Segments) == 0 {
+		return nil, fmt.Errorf("没有可用的分段")
+	}
+	page := req.Page
+	if page <= 0 {
+		page = 1
+	}
+
+	info, err := s.getCompleteVideoInfo(bvid)
+	if err != nil {
+		return nil, err
+	}
+	pageTitle := ""
+	for _, p := range info.Pages {
+		if p.Page == page {
+			pageTitle = p.Part
+			break
+		}
+	}
+
+	songs := make([]models.Song, 0, len(req.Segments))
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// 所有分段共享同一个流源
+		source := models.StreamSource{ID: uuid.NewString(), BVID: bvid}
+		if err := tx.Create(&source).Error; err != nil {
+			return err
+		}
+
+		for i, seg := range req.Segments {
+			name := strings.TrimSpace(seg.Title)
+			if name == "" {
+				name = fmt.Sprintf("%s #%d", info.Title, i+1)
+			}
+			singer := strings.TrimSpace(seg.Singer)
+			if singer == "" {
+				singer = info.Author
+			}
+			songs = append(songs, models.Song{
+				ID:            uuid.NewString(),
+				BVID:          bvid,
+				Name:          name,
+				Singer:        singer,
+				Cover:         info.Cover,
+				SourceID:      source.ID,
+				SkipStartTime: seg.Start,
+				SkipEndTime:   seg.End,
+				PageNumber:    page,
+				PageTitle:     pageTitle,
+				VideoTitle:    info.Title,
+				TotalPages:    len(info.Pages),
+			})
+		}
+		if err := tx.Create(&songs).Error; err != nil {
+			return err
+		}
+
+		if req.FavoriteID == "" {
+			return nil
+		}
+		ids := make([]string, 0, len(songs))
+		for _, song := range songs {
+			ids = append(ids, song.ID)
+		}
+		if _, err := appendSongRefs(tx, req.FavoriteID, ids); err != nil {
+			return err
+		}
+		return tx.Model(&models.Favorite{}).Where("id = ?", req.FavoriteID).Update("updated_at", time.Now()).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return songs, nil
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"context"
 	"fmt"
+	"half-beat-player/internal/lyricprovider"
 	"half-beat-player/internal/proxy"
 	"net"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
@@ -24,6 +26,10 @@ type Service struct {
 	dataDir    string // 数据目录用于存储 cookie
 	appCtx     context.Context
 	audioProxy *proxy.AudioProxy
+	wbi        wbiKeyCache // WBI 签名密钥缓存
+	lyricSrc   *lyricprovider.Registry
+	search     searchIndex // 本地歌曲全文索引
+	snapshotMu sync.Mutex  // 串行化数据库快照的创建和清理
 }
 
 func NewService(db *gorm.DB, dataDir string) *Service {
@@ -57,6 +63,7 @@ func NewService(db *gorm.DB, dataDir string) *Service {
 		cookieJar:  jar,
 		httpClient: client,
 		dataDir:    dataDir,
+		lyricSrc:   lyricprovider.NewRegistry(),
 	}
 
 	// 在启动时尝试恢复之前的登录状态
@@ -117,6 +124,15 @@ func (s *Service) getAudioProxyURL(audioURL string) string {
 
 func (s *Service) SetAppContext(ctx context.Context) {
 	s.appCtx = ctx
+	// 定时快照随应用生命周期启动和停止
+	go s.runSnapshotScheduler(ctx)
+}
+
+// emitEvent sends an event to the frontend; it is a no-op before startup.
+func (s *Service) emitEvent(name string, data any) {
+	if s.appCtx != nil {
+		runtime.EventsEmit(s.appCtx, name, data)
+	}
 }
 
 // 窗口控制方法
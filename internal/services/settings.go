@@ -56,6 +56,7 @@ func (s *Service) GetPlayerSetting() (models.PlayerSetting, error) {
 					"currentThemeId":       "light",
 					"volumeCompensationDb": 0,
 					"songVolumeOffsets":    map[string]any{},
+					"autoNormalize":        false,
 				},
 			}
 			if err := s.db.Create(&setting).Error; err != nil {
@@ -80,6 +81,25 @@ func getConfigString(m map[string]any, key string, defaultValue string) string {
 	return defaultValue
 }
 
+// Helper to get bool from config map
+func getConfigBool(m map[string]any, key string, defaultValue bool) bool {
+	if v, ok := m[key].(bool); ok {
+		return v
+	}
+	return defaultValue
+}
+
+// Helper to get number from config map (JSON numbers decode as float64)
+func getConfigFloat(m map[string]any, key string, defaultValue float64) float64 {
+	switch v := m[key].(type) {
+	case float64:
+		return v
+	case int:
+		return float64(v)
+	}
+	return defaultValue
+}
+
 // formatThemesJSON converts theme slice to JSON string
 func formatThemesJSON(themes []models.Theme) (string, error) {
     data, err := json.Marshal(themes)
@@ -0,0 +1,383 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"half-beat-player/internal/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Smart playlist condition fields.
+const (
+	SmartFieldName       = "name"
+	SmartFieldSinger     = "singer"
+	SmartFieldVideoTitle = "videoTitle"
+	SmartFieldBVID       = "bvid"
+	SmartFieldUploader   = "uploader"   // UP 主 mid 或名称
+	SmartFieldAddedAt    = "addedAt"    // 加入任一歌单的时间
+	SmartFieldCreatedAt  = "createdAt"  // 歌曲创建时间
+	SmartFieldLyric      = "lyric"      // 是否有歌词
+	SmartFieldSkipWindow = "skipWindow" // 是否设置了跳过区间
+	SmartFieldDownloaded = "downloaded" // 是否已下载到本地
+	SmartFieldFavorite   = "favorite"   // 是否在某个歌单中
+	SmartFieldTag        = "tag"        // 是否带有某个标签
+)
+
+// Smart playlist condition operators.
+const (
+	SmartOpContains    = "contains"
+	SmartOpNotContains = "notContains"
+	SmartOpEquals      = "equals"
+	SmartOpNotEquals   = "notEquals"
+	SmartOpStartsWith  = "startsWith"
+	SmartOpWithinDays  = "withinDays"
+	SmartOpOlderThan   = "olderThanDays"
+	SmartOpIsTrue      = "isTrue"
+	SmartOpIsFalse     = "isFalse"
+)
+
+// Smart playlist sort keys; an empty SortBy keeps the insertion order.
+const (
+	SmartSortName      = "name"
+	SmartSortSinger    = "singer"
+	SmartSortCreatedAt = "createdAt"
+	SmartSortAddedAt   = "addedAt"
+	SmartSortRandom    = "random"
+)
+
+// smartMaxDepth limits group nesting to keep generated SQL reasonable.
+const smartMaxDepth = 8
+
+// smartTextColumns maps text fields to song columns.
+var smartTextColumns = map[string]string{
+	SmartFieldName:       "songs.name",
+	SmartFieldSinger:     "songs.singer",
+	SmartFieldVideoTitle: "songs.video_title",
+	SmartFieldBVID:       "songs.bvid",
+}
+
+// smartQuery accumulates a SQL expression and its arguments.
+type smartQuery struct {
+	s    *Service
+	now  time.Time
+	sql  strings.Builder
+	args []any
+}
+
+// escapeLike escapes LIKE wildcards; patterns use ESCAPE '\'.
+func escapeLike(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(v)
+}
+
+func (q *smartQuery) writeGroup(g models.SmartRules, depth int) error {
+	if depth > smartMaxDepth {
+		return fmt.Errorf("规则嵌套过深")
+	}
+	joiner := " AND "
+	switch g.Match {
+	case "", "all":
+	case "any":
+		joiner = " OR "
+	default:
+		return fmt.Errorf("未知的匹配方式: %s", g.Match)
+	}
+
+	if len(g.Conditions) == 0 && len(g.Groups) == 0 {
+		// 空组：all 匹配全部，any 不匹配任何
+		if joiner == " OR " {
+			q.sql.WriteString("0")
+		} else {
+			q.sql.WriteString("1")
+		}
+		return nil
+	}
+
+	q.sql.WriteString("(")
+	first := true
+	for _, c := range g.Conditions {
+		if !first {
+			q.sql.WriteString(joiner)
+		}
+		first = false
+		if err := q.writeCondition(c); err != nil {
+			return err
+		}
+	}
+	for _, sub := range g.Groups {
+		if !first {
+			q.sql.WriteString(joiner)
+		}
+		first = false
+		if err := q.writeGroup(sub, depth+1); err != nil {
+			return err
+		}
+	}
+	q.sql.WriteString(")")
+	return nil
+}
+
+func (q *smartQuery) write(sql string, args ...any) {
+	q.sql.WriteString(sql)
+	q.args = append(q.args, args...)
+}
+
+// writeBool writes expr or its negation depending on an isTrue/isFalse op.
+func (q *smartQuery) writeBool(c models.SmartCondition, expr string, args ...any) error {
+	switch c.Op {
+	case SmartOpIsTrue:
+		q.write("("+expr+")", args...)
+	case SmartOpIsFalse:
+		q.write("NOT ("+expr+")", args...)
+	default:
+		return fmt.Errorf("字段 %s 不支持操作 %s", c.Field, c.Op)
+	}
+	return nil
+}
+
+// writeDays writes a withinDays/olderThanDays comparison on a time expression.
+func (q *smartQuery) writeDays(c models.SmartCondition, column string) (string, []any, error) {
+	days, err := strconv.ParseFloat(strings.TrimSpace(c.Value), 64)
+	if err != nil || days < 0 {
+		return "", nil, fmt.Errorf("无效的天数: %s", c.Value)
+	}
+	since := q.now.Add(-time.Duration(days * float64(24*time.Hour)))
+	switch c.Op {
+	case SmartOpWithinDays:
+		return column + " >= ?", []any{since}, nil
+	case SmartOpOlderThan:
+		return column + " < ?", []any{since}, nil
+	}
+	return "", nil, fmt.Errorf("字段 %s 不支持操作 %s", c.Field, c.Op)
+}
+
+func (q *smartQuery) writeCondition(c models.SmartCondition) error {
+	if col, ok := smartTextColumns[c.Field]; ok {
+		v := strings.TrimSpace(c.Value)
+		switch c.Op {
+		case SmartOpContains:
+			q.write(col+` LIKE ? ESCAPE '\'`, "%"+escapeLike(v)+"%")
+		case SmartOpNotContains:
+			q.write(col+` NOT LIKE ? ESCAPE '\'`, "%"+escapeLike(v)+"%")
+		case SmartOpStartsWith:
+			q.write(col+` LIKE ? ESCAPE '\'`, escapeLike(v)+"%")
+		case SmartOpEquals:
+			q.write(col+" = ? COLLATE NOCASE", v)
+		case SmartOpNotEquals:
+			q.write(col+" <> ? COLLATE NOCASE", v)
+		default:
+			return fmt.Errorf("字段 %s 不支持操作 %s", c.Field, c.Op)
+		}
+		return nil
+	}
+
+	switch c.Field {
+	case SmartFieldUploader:
+		v := strings.TrimSpace(c.Value)
+		switch c.Op {
+		case SmartOpEquals:
+			q.write("(songs.singer_id = ? OR songs.singer = ? COLLATE NOCASE)", v, v)
+		case SmartOpNotEquals:
+			q.write("NOT (songs.singer_id = ? OR songs.singer = ? COLLATE NOCASE)", v, v)
+		default:
+			return fmt.Errorf("字段 %s 不支持操作 %s", c.Field, c.Op)
+		}
+	case SmartFieldAddedAt:
+		cmp, args, err := q.writeDays(c, "r.added_at")
+		if err != nil {
+			return err
+		}
+		if c.Op == SmartOpOlderThan {
+			// 最早一次加入早于给定时间
+			q.write("(SELECT MIN(r.added_at) FROM song_refs r WHERE r.song_id = songs.id) < ?", args...)
+			return nil
+		}
+		q.write("EXISTS (SELECT 1 FROM song_refs r WHERE r.song_id = songs.id AND "+cmp+")", args...)
+	case SmartFieldCreatedAt:
+		cmp, args, err := q.writeDays(c, "songs.created_at")
+		if err != nil {
+			return err
+		}
+		q.write(cmp, args...)
+	case SmartFieldLyric:
+		return q.writeBool(c, "songs.lyric <> '' OR EXISTS (SELECT 1 FROM lyric_mappings lm WHERE lm.id = songs.id AND lm.lyric <> '')")
+	case SmartFieldSkipWindow:
+		return q.writeBool(c, "songs.skip_start_time > 0 OR songs.skip_end_time > 0")
+	case SmartFieldDownloaded:
+		stems, err := q.s.downloadedStems()
+		if err != nil {
+			return err
+		}
+		if len(stems) == 0 {
+			return q.writeBool(c, "0")
+		}
+		// 与 getLocalAudioFilename 的命名规则保持一致
+		return q.writeBool(c,
+			"(songs.id <> songs.bvid AND songs.id IN ?) OR (songs.id = songs.bvid AND (songs.bvid IN ? OR songs.bvid || '-P' || MAX(songs.page_number, 1) IN ?))",
+			stems, stems, stems)
+	case SmartFieldFavorite:
+		if c.Value == "" {
+			return fmt.Errorf("缺少歌单 ID")
+		}
+		return q.writeBool(c, "EXISTS (SELECT 1 FROM song_refs r WHERE r.song_id = songs.id AND r.favorite_id = ?)", c.Value)
+	case SmartFieldTag:
+		tag := strings.TrimSpace(c.Value)
+		if tag == "" {
+			return fmt.Errorf("缺少标签")
+		}
+		return q.writeBool(c, "EXISTS (SELECT 1 FROM song_tags t WHERE t.song_id = songs.id AND LOWER(t.tag) = ?)", strings.ToLower(tag))
+	default:
+		return fmt.Errorf("未知的规则字段: %s", c.Field)
+	}
+	return nil
+}
+
+// downloadedStems lists the base names of downloaded audio files.
+func (s *Service) downloadedStems() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dataDir, downloadsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	stems := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".m4s" {
+			continue
+		}
+		stems = append(stems, strings.TrimSuffix(e.Name(), ".m4s"))
+	}
+	return stems, nil
+}
+
+// smartSongsQuery compiles rules into a query over songs.
+func (s *Service) smartSongsQuery(tx *gorm.DB, rules models.SmartRules) (*gorm.DB, error) {
+	q := &smartQuery{s: s, now: time.Now()}
+	if err := q.writeGroup(rules, 0); err != nil {
+		return nil, err
+	}
+	db := tx.Model(&models.Song{}).Where(q.sql.String(), q.args...)
+
+	switch rules.SortBy {
+	case "":
+		db = db.Order("songs.created_at, songs.id")
+	case SmartSortName:
+		db = db.Order(orderDir("songs.name COLLATE NOCASE", rules.Desc))
+	case SmartSortSinger:
+		db = db.Order(orderDir("songs.singer COLLATE NOCASE", rules.Desc))
+	case SmartSortCreatedAt:
+		db = db.Order(orderDir("songs.created_at", rules.Desc))
+	case SmartSortAddedAt:
+		db = db.Order(orderDir("(SELECT MAX(r.added_at) FROM song_refs r WHERE r.song_id = songs.id)", rules.Desc))
+	case SmartSortRandom:
+		db = db.Order("RANDOM()")
+	default:
+		return nil, fmt.Errorf("不支持的排序方式: %s", rules.SortBy)
+	}
+	if rules.Limit > 0 {
+		db = db.Limit(rules.Limit)
+	}
+	return db, nil
+}
+
+func orderDir(expr string, desc bool) string {
+	if desc {
+		return expr + " DESC"
+	}
+	return expr + " ASC"
+}
+
+// evaluateSmartRules returns the songs matched by rules.
+func (s *Service) evaluateSmartRules(tx *gorm.DB, rules models.SmartRules) ([]models.Song, error) {
+	db, err := s.smartSongsQuery(tx, rules)
+	if err != nil {
+		return nil, err
+	}
+	songs := []models.Song{}
+	if err := db.Find(&songs).Error; err != nil {
+		return nil, err
+	}
+	return songs, nil
+}
+
+// smartSongRefs evaluates a smart favorite into synthetic refs (ID 0) so the
+// frontend can treat it like a normal favorite.
+func (s *Service) smartSongRefs(tx *gorm.DB, fav models.Favorite) ([]models.SongRef, error) {
+	if fav.Rules == nil {
+		return []models.SongRef{}, nil
+	}
+	db, err := s.smartSongsQuery(tx, *fav.Rules)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	if err := db.Pluck("songs.id", &ids).Error; err != nil {
+		return nil, err
+	}
+	refs := make([]models.SongRef, 0, len(ids))
+	for i, id := range ids {
+		refs = append(refs, models.SongRef{
+			FavoriteID: fav.ID,
+			SongID:     id,
+			Position:   int64(i+1) * songRefPositionGap,
+		})
+	}
+	return refs, nil
+}
+
+// PreviewSmartRules returns the songs the rules currently match, without saving.
+func (s *Service) PreviewSmartRules(rules models.SmartRules) ([]models.Song, error) {
+	return s.evaluateSmartRules(s.db, rules)
+}
+
+// SaveSmartFavorite creates or updates a smart favorite. The rules are
+// validated by compiling them; no song refs are stored.
+func (s *Service) SaveSmartFavorite(fav models.Favorite) (models.Favorite, error) {
+	if fav.Rules == nil {
+		return fav, fmt.Errorf("智能歌单缺少规则")
+	}
+	if _, err := s.smartSongsQuery(s.db, *fav.Rules); err != nil {
+		return fav, err
+	}
+	if fav.ID == "" {
+		fav.ID = "FavList-" + uuid.NewString()
+	}
+	fav.Kind = models.FavoriteKindSmart
+	fav.SongIDs = nil
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var existing models.Favorite
+		err := tx.Select("id", "kind").Where("id = ?", fav.ID).Limit(1).Find(&existing).Error
+		if err != nil {
+			return err
+		}
+		if existing.ID == "" {
+			return tx.Create(&fav).Error
+		}
+		if existing.Kind != models.FavoriteKindSmart {
+			return fmt.Errorf("不能把普通歌单改为智能歌单")
+		}
+		// 只更新标题和规则，文件夹、排序和创建时间保持不变
+		fav.UpdatedAt = time.Now()
+		if err := tx.Model(&models.Favorite{ID: fav.ID}).Select("title", "rules", "kind", "updated_at").Updates(&fav).Error; err != nil {
+			return err
+		}
+		return tx.First(&fav, "id = ?", fav.ID).Error
+	})
+	if err != nil {
+		return fav, err
+	}
+	refs, err := s.smartSongRefs(s.db, fav)
+	if err != nil {
+		return fav, err
+	}
+	fav.SongIDs = refs
+	return fav, nil
+}
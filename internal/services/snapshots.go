@@ -0,0 +1,394 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"half-beat-player/internal/models"
+
+	"gorm.io/gorm"
+)
+
+const snapshotsDir = "snapshots"
+
+// 快照原因，写在文件名里
+const (
+	SnapshotScheduled     = "scheduled"
+	SnapshotManual        = "manual"
+	SnapshotBeforeClear   = "before-clear"
+	SnapshotBeforeImport  = "before-import"
+	SnapshotBeforeMerge   = "before-merge"
+	SnapshotBeforeRestore = "before-restore"
+)
+
+const snapshotTimeLayout = "20060102-150405.000"
+
+var snapshotNameRe = regexp.MustCompile(`^half-beat-(\d{8}-\d{6}\.\d{3})-([a-z-]+)\.db$`)
+
+// snapshotCheckInterval is how often the scheduler checks whether a snapshot is due.
+const snapshotCheckInterval = 10 * time.Minute
+
+// SnapshotPolicy controls automatic snapshots. It is stored in the
+// "snapshotPolicy" setting.
+type SnapshotPolicy struct {
+	Enabled       bool `json:"enabled"`
+	IntervalHours int  `json:"intervalHours"`
+	Keep          int  `json:"keep"`          // 保留的定时快照数量
+	KeepBeforeOps int  `json:"keepBeforeOps"` // 保留的操作前快照数量
+	MaxAgeDays    int  `json:"maxAgeDays"`    // 0 表示不按时间清理
+}
+
+func defaultSnapshotPolicy() SnapshotPolicy {
+	return SnapshotPolicy{Enabled: true, IntervalHours: 24, Keep: 7, KeepBeforeOps: 5}
+}
+
+// SnapshotInfo describes one database snapshot on disk.
+type SnapshotInfo struct {
+	Name      string    `json:"name"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+	Size      int64     `json:"size"`
+}
+
+// GetSnapshotPolicy returns the effective snapshot policy.
+func (s *Service) GetSnapshotPolicy() SnapshotPolicy {
+	policy := defaultSnapshotPolicy()
+	setting, err := s.GetPlayerSetting()
+	if err != nil {
+		return policy
+	}
+	raw, ok := setting.Config["snapshotPolicy"]
+	if !ok {
+		return policy
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return policy
+	}
+	_ = json.Unmarshal(data, &policy)
+	return policy
+}
+
+// SetSnapshotPolicy stores the policy and prunes snapshots it no longer keeps.
+func (s *Service) SetSnapshotPolicy(policy SnapshotPolicy) error {
+	if policy.IntervalHours <= 0 {
+		return fmt.Errorf("快照间隔必须大于 0")
+	}
+	// 操作前快照至少保留一份，否则刚写入就会被清理，操作无法撤销
+	if policy.Keep < 1 || policy.KeepBeforeOps < 1 || policy.MaxAgeDays < 0 {
+		return fmt.Errorf("无效的快照保留策略")
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return err
+	}
+	var raw map[string]any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if err := s.SavePlayerSetting(models.PlayerSetting{Config: map[string]any{"snapshotPolicy": raw}}); err != nil {
+		return err
+	}
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	return s.pruneSnapshots(policy, "")
+}
+
+// ListSnapshots returns the snapshots on disk, newest first.
+func (s *Service) ListSnapshots() ([]SnapshotInfo, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dataDir, snapshotsDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []SnapshotInfo{}, nil
+		}
+		return nil, err
+	}
+	out := []SnapshotInfo{}
+	for _, e := range entries {
+		m := snapshotNameRe.FindStringSubmatch(e.Name())
+		if m == nil || e.IsDir() {
+			continue
+		}
+		created, err := time.ParseInLocation(snapshotTimeLayout, m[1], time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		out = append(out, SnapshotInfo{Name: e.Name(), Reason: m[2], CreatedAt: created, Size: info.Size()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
+
+// CreateSnapshot takes a manual snapshot. Manual snapshots are never rotated
+// out; remove them with DeleteSnapshot.
+func (s *Service) CreateSnapshot() (SnapshotInfo, error) {
+	return s.takeSnapshot(SnapshotManual)
+}
+
+// DeleteSnapshot removes one snapshot by name.
+func (s *Service) DeleteSnapshot(name string) error {
+	path, err := s.snapshotPath(name)
+	if err != nil {
+		return err
+	}
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	return os.Remove(path)
+}
+
+// RestoreSnapshot replaces the current database content with a snapshot.
+// The current state is snapshotted first so the restore can be undone.
+func (s *Service) RestoreSnapshot(name string) error {
+	path, err := s.snapshotPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("快照不存在: %s", name)
+	}
+	if err := s.snapshotBefore(SnapshotBeforeRestore); err != nil {
+		return err
+	}
+
+	err = s.db.Connection(func(conn *gorm.DB) error {
+		// ATTACH 只对当前连接生效，所以整个恢复都在同一个连接上完成
+		if err := conn.Exec("ATTACH DATABASE ? AS snap", path).Error; err != nil {
+			return fmt.Errorf("打开快照失败: %w", err)
+		}
+		defer conn.Exec("DETACH DATABASE snap")
+		return conn.Transaction(restoreAttachedSnapshot)
+	})
+	if err != nil {
+		return err
+	}
+	_ = s.restoreLogin()
+	return nil
+}
+
+// restoreAttachedSnapshot copies every table of the attached "snap" schema
+// into main. Columns are matched by name, so snapshots taken before a
+// migration still restore; tables the snapshot predates are emptied. The
+// search index is left alone, its triggers queue every copied song.
+func restoreAttachedSnapshot(tx *gorm.DB) error {
+	mainTables, err := snapshotTables(tx, "main")
+	if err != nil {
+		return err
+	}
+	snapTables, err := snapshotTables(tx, "snap")
+	if err != nil {
+		return err
+	}
+	inSnap := map[string]bool{}
+	for _, t := range snapTables {
+		inSnap[t] = true
+	}
+
+	for _, table := range mainTables {
+		if err := tx.Exec(fmt.Sprintf(`DELETE FROM main.%q`, table)).Error; err != nil {
+			return err
+		}
+		if !inSnap[table] {
+			continue
+		}
+		cols, err := sharedColumns(tx, table)
+		if err != nil {
+			return err
+		}
+		if len(cols) == 0 {
+			continue
+		}
+		list := strings.Join(cols, ", ")
+		if err := tx.Exec(fmt.Sprintf(`INSERT INTO main.%q (%s) SELECT %s FROM snap.%q`, table, list, list, table)).Error; err != nil {
+			return fmt.Errorf("恢复表 %s 失败: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// snapshotTables lists the regular tables of schema, skipping SQLite
+// internals and the search index.
+func snapshotTables(tx *gorm.DB, schema string) ([]string, error) {
+	var names []string
+	err := tx.Raw(fmt.Sprintf(`SELECT name FROM %s.sqlite_master WHERE type = 'table'`, schema)).Scan(&names).Error
+	if err != nil {
+		return nil, err
+	}
+	out := names[:0]
+	for _, name := range names {
+		if strings.HasPrefix(name, "sqlite_") || isSearchIndexTable(name) {
+			continue
+		}
+		out = append(out, name)
+	}
+	return out, nil
+}
+
+func isSearchIndexTable(name string) bool {
+	return strings.HasPrefix(name, "song_fts") || strings.HasPrefix(name, "song_search") || name == "search_meta"
+}
+
+// sharedColumns returns the quoted columns table has in both main and snap.
+func sharedColumns(tx *gorm.DB, table string) ([]string, error) {
+	columns := func(schema string) ([]string, error) {
+		var cols []struct{ Name string }
+		err := tx.Raw(fmt.Sprintf(`SELECT name FROM pragma_table_info(%s, %s)`, quoteSQLString(table), quoteSQLString(schema))).
+			Scan(&cols).Error
+		names := make([]string, 0, len(cols))
+		for _, c := range cols {
+			names = append(names, c.Name)
+		}
+		return names, err
+	}
+	mainCols, err := columns("main")
+	if err != nil {
+		return nil, err
+	}
+	snapCols, err := columns("snap")
+	if err != nil {
+		return nil, err
+	}
+	have := map[string]bool{}
+	for _, c := range snapCols {
+		have[c] = true
+	}
+	out := []string{}
+	for _, c := range mainCols {
+		if have[c] {
+			out = append(out, fmt.Sprintf("%q", c))
+		}
+	}
+	return out, nil
+}
+
+func quoteSQLString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// snapshotPath validates a snapshot name and returns its path.
+func (s *Service) snapshotPath(name string) (string, error) {
+	if !snapshotNameRe.MatchString(name) {
+		return "", fmt.Errorf("无效的快照名称: %s", name)
+	}
+	return filepath.Join(s.dataDir, snapshotsDir, name), nil
+}
+
+// snapshotBefore takes a snapshot ahead of a destructive operation. A failed
+// snapshot aborts the operation rather than risk losing data.
+func (s *Service) snapshotBefore(reason string) error {
+	if _, err := s.takeSnapshot(reason); err != nil {
+		return fmt.Errorf("创建数据库快照失败: %w", err)
+	}
+	return nil
+}
+
+// takeSnapshot writes a consistent copy of the database with VACUUM INTO and
+// rotates old snapshots.
+func (s *Service) takeSnapshot(reason string) (SnapshotInfo, error) {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	dir := filepath.Join(s.dataDir, snapshotsDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return SnapshotInfo{}, err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("half-beat-%s-%s.db", now.Format(snapshotTimeLayout), reason)
+	path := filepath.Join(dir, name)
+	// VACUUM INTO 拒绝覆盖已存在的文件
+	_ = os.Remove(path)
+	if err := s.db.Exec("VACUUM INTO ?", path).Error; err != nil {
+		return SnapshotInfo{}, err
+	}
+	info := SnapshotInfo{Name: name, Reason: reason, CreatedAt: now}
+	if st, err := os.Stat(path); err == nil {
+		info.Size = st.Size()
+	}
+	if err := s.pruneSnapshots(s.GetSnapshotPolicy(), name); err != nil {
+		fmt.Printf("[Snapshot] 清理旧快照失败: %v\n", err)
+	}
+	return info, nil
+}
+
+// pruneSnapshots applies the retention policy. Scheduled and pre-operation
+// snapshots are counted separately; manual ones and the snapshot named
+// current (the one just taken) are never removed. Callers hold snapshotMu.
+func (s *Service) pruneSnapshots(policy SnapshotPolicy, current string) error {
+	list, err := s.ListSnapshots()
+	if err != nil {
+		return err
+	}
+	var cutoff time.Time
+	if policy.MaxAgeDays > 0 {
+		cutoff = time.Now().AddDate(0, 0, -policy.MaxAgeDays)
+	}
+	scheduled, beforeOps := 0, 0
+	for _, snap := range list {
+		var keep bool
+		switch snap.Reason {
+		case SnapshotManual:
+			continue
+		case SnapshotScheduled:
+			scheduled++
+			keep = scheduled <= policy.Keep
+		default:
+			beforeOps++
+			keep = beforeOps <= policy.KeepBeforeOps
+		}
+		if snap.Name == current || keep && (cutoff.IsZero() || snap.CreatedAt.After(cutoff)) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dataDir, snapshotsDir, snap.Name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// runSnapshotScheduler takes a scheduled snapshot whenever the newest one is
+// older than the policy interval, until ctx is cancelled.
+func (s *Service) runSnapshotScheduler(ctx context.Context) {
+	ticker := time.NewTicker(snapshotCheckInterval)
+	defer ticker.Stop()
+	for {
+		s.snapshotIfDue()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) snapshotIfDue() {
+	policy := s.GetSnapshotPolicy()
+	if !policy.Enabled || policy.IntervalHours <= 0 {
+		return
+	}
+	list, err := s.ListSnapshots()
+	if err != nil {
+		fmt.Printf("[Snapshot] 读取快照列表失败: %v\n", err)
+		return
+	}
+	for _, snap := range list {
+		if snap.Reason == SnapshotScheduled {
+			if time.Since(snap.CreatedAt) < time.Duration(policy.IntervalHours)*time.Hour {
+				return
+			}
+			break
+		}
+	}
+	if _, err := s.takeSnapshot(SnapshotScheduled); err != nil {
+		fmt.Printf("[Snapshot] 定时快照失败: %v\n", err)
+	}
+}
@@ -0,0 +1,465 @@
+package services
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"half-beat-player/internal/lrc"
+	"half-beat-player/internal/models"
+	"half-beat-player/internal/searchtext"
+
+	"gorm.io/gorm"
+)
+
+// searchIndexVersion changes whenever the indexed text changes shape; a new
+// version rebuilds the index on the next search.
+const searchIndexVersion = "1"
+
+const (
+	searchDefaultLimit = 50
+	searchMaxLimit     = 500
+	// 没有 FTS5 或只有短词时，先取这么多候选再在内存中打分
+	searchScanLimit = 2000
+	// 歌词片段最多保留的字符数
+	searchSnippetRunes = 60
+)
+
+// searchIndex tracks the local search index. The index lives in song_fts
+// (FTS5, trigram tokenizer) when SQLite is built with the sqlite_fts5 tag,
+// otherwise in the plain table song_search queried with LIKE.
+//
+// Triggers on songs and lyric_mappings queue changed song ids in
+// song_search_dirty; the queue is applied before every search, so no write
+// path needs to know about the index.
+type searchIndex struct {
+	once sync.Once
+	mu   sync.Mutex
+	fts  bool
+	err  error
+}
+
+// SearchSnippet is a matched field of a search hit. Ranges are rune offsets
+// into Text to highlight.
+type SearchSnippet struct {
+	Field  string             `json:"field"` // name, singer, videoTitle, pageTitle, lyric, pinyin
+	Text   string             `json:"text"`
+	Ranges []searchtext.Range `json:"ranges"`
+}
+
+// SongSearchHit is a song found by SearchLibrary, best hits first.
+type SongSearchHit struct {
+	Song     models.Song     `json:"song"`
+	Score    float64         `json:"score"`
+	Snippets []SearchSnippet `json:"snippets"`
+}
+
+// searchRow is one row of the index table.
+type searchRow struct {
+	SongID     string
+	Name       string
+	Singer     string
+	VideoTitle string
+	PageTitle  string
+	Lyric      string
+	Pinyin     string
+}
+
+// 各列在打分中的权重，顺序与索引表的列一致（song_id 不参与）
+var searchColumnWeights = []struct {
+	column string
+	weight float64
+}{
+	{"name", 10},
+	{"singer", 6},
+	{"video_title", 3},
+	{"page_title", 3},
+	{"lyric", 1},
+	{"pinyin", 4},
+}
+
+func (ix *searchIndex) table() string {
+	if ix.fts {
+		return "song_fts"
+	}
+	return "song_search"
+}
+
+// setupSearchIndex creates the index tables and triggers if needed and queues
+// a full rebuild when the index version or mode changed.
+func setupSearchIndex(db *gorm.DB) (bool, error) {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS search_meta (key TEXT PRIMARY KEY, value TEXT)`,
+		`CREATE TABLE IF NOT EXISTS song_search_dirty (song_id TEXT PRIMARY KEY)`,
+		`CREATE TRIGGER IF NOT EXISTS songs_search_ai AFTER INSERT ON songs BEGIN
+			INSERT OR IGNORE INTO song_search_dirty (song_id) VALUES (NEW.id);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS songs_search_au AFTER UPDATE OF id, name, singer, video_title, page_title, lyric ON songs BEGIN
+			INSERT OR IGNORE INTO song_search_dirty (song_id) VALUES (OLD.id);
+			INSERT OR IGNORE INTO song_search_dirty (song_id) VALUES (NEW.id);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS songs_search_ad AFTER DELETE ON songs BEGIN
+			INSERT OR IGNORE INTO song_search_dirty (song_id) VALUES (OLD.id);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS lyric_mappings_search_ai AFTER INSERT ON lyric_mappings BEGIN
+			INSERT OR IGNORE INTO song_search_dirty (song_id) VALUES (NEW.id);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS lyric_mappings_search_au AFTER UPDATE OF id, lyric ON lyric_mappings BEGIN
+			INSERT OR IGNORE INTO song_search_dirty (song_id) VALUES (OLD.id);
+			INSERT OR IGNORE INTO song_search_dirty (song_id) VALUES (NEW.id);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS lyric_mappings_search_ad AFTER DELETE ON lyric_mappings BEGIN
+			INSERT OR IGNORE INTO song_search_dirty (song_id) VALUES (OLD.id);
+		END`,
+	}
+	for _, stmt := range stmts {
+		if err := db.Exec(stmt).Error; err != nil {
+			return false, fmt.Errorf("创建搜索索引失败: %w", err)
+		}
+	}
+
+	fts := true
+	if err := db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS song_fts USING fts5(
+		song_id UNINDEXED, name, singer, video_title, page_title, lyric, pinyin, tokenize = 'trigram'
+	)`).Error; err != nil {
+		// 未使用 sqlite_fts5 构建标签编译时退化为 LIKE 查询
+		fmt.Printf("[Search] FTS5 不可用，本地搜索使用 LIKE: %v\n", err)
+		fts = false
+		if err := db.Exec(`CREATE TABLE IF NOT EXISTS song_search (
+			song_id TEXT PRIMARY KEY, name TEXT, singer TEXT, video_title TEXT, page_title TEXT, lyric TEXT, pinyin TEXT
+		)`).Error; err != nil {
+			return false, fmt.Errorf("创建搜索索引失败: %w", err)
+		}
+	}
+
+	state := searchIndexVersion + ":like"
+	table := "song_search"
+	if fts {
+		state = searchIndexVersion + ":fts5"
+		table = "song_fts"
+	}
+	var current string
+	if err := db.Raw(`SELECT COALESCE(MAX(value), '') FROM search_meta WHERE key = 'song_index'`).
+		Scan(&current).Error; err != nil {
+		return false, err
+	}
+	if current == state {
+		return fts, nil
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM " + table).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`INSERT OR IGNORE INTO song_search_dirty (song_id) SELECT id FROM songs`).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`INSERT OR REPLACE INTO search_meta (key, value) VALUES ('song_index', ?)`, state).Error
+	})
+	return fts, err
+}
+
+// lyricPlainText strips LRC time tags, keeping one lyric line per row.
+func lyricPlainText(text string) string {
+	doc := lrc.Parse(text)
+	if len(doc.Lines) == 0 {
+		return text
+	}
+	lines := make([]string, 0, len(doc.Lines))
+	for _, l := range doc.Lines {
+		if t := strings.TrimSpace(l.Text); t != "" {
+			lines = append(lines, t)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// songLyricTexts returns song id -> lyric text for ids, preferring the
+// lyric mapping over Song.Lyric.
+func songLyricTexts(tx *gorm.DB, songs []models.Song) (map[string]string, error) {
+	out := make(map[string]string, len(songs))
+	ids := make([]string, 0, len(songs))
+	for _, song := range songs {
+		ids = append(ids, song.ID)
+		if song.Lyric != "" {
+			out[song.ID] = song.Lyric
+		}
+	}
+	if len(ids) == 0 {
+		return out, nil
+	}
+	var mappings []models.LyricMapping
+	if err := tx.Select("id, lyric").Where("id IN ? AND lyric <> ''", ids).Find(&mappings).Error; err != nil {
+		return nil, err
+	}
+	for _, m := range mappings {
+		out[m.ID] = m.Lyric
+	}
+	return out, nil
+}
+
+// songPinyin spells name and singer as full pinyin and initials.
+func songPinyin(song models.Song) string {
+	nameFull, nameInitials := searchtext.Pinyin(song.Name)
+	singerFull, singerInitials := searchtext.Pinyin(song.Singer)
+	return strings.Join(strings.Fields(strings.Join([]string{nameFull, nameInitials, singerFull, singerInitials}, " ")), " ")
+}
+
+// buildSearchRow folds the searchable text of a song.
+func buildSearchRow(song models.Song, lyric string) searchRow {
+	return searchRow{
+		SongID:     song.ID,
+		Name:       searchtext.Fold(song.Name),
+		Singer:     searchtext.Fold(song.Singer),
+		VideoTitle: searchtext.Fold(song.VideoTitle),
+		PageTitle:  searchtext.Fold(song.PageTitle),
+		Lyric:      searchtext.Fold(lyricPlainText(lyric)),
+		Pinyin:     songPinyin(song),
+	}
+}
+
+// syncSearchIndex applies queued song changes to the index.
+func (s *Service) syncSearchIndex() error {
+	ix := &s.search
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.once.Do(func() {
+		ix.fts, ix.err = setupSearchIndex(s.db)
+	})
+	if ix.err != nil {
+		return ix.err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var ids []string
+		if err := tx.Raw(`SELECT song_id FROM song_search_dirty`).Scan(&ids).Error; err != nil {
+			return err
+		}
+		for start := 0; start < len(ids); start += 500 {
+			chunk := ids[start:min(start+500, len(ids))]
+			if err := tx.Exec(`DELETE FROM song_search_dirty WHERE song_id IN ?`, chunk).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("DELETE FROM "+ix.table()+" WHERE song_id IN ?", chunk).Error; err != nil {
+				return err
+			}
+			var songs []models.Song
+			if err := tx.Where("id IN ?", chunk).Find(&songs).Error; err != nil {
+				return err
+			}
+			lyrics, err := songLyricTexts(tx, songs)
+			if err != nil {
+				return err
+			}
+			for _, song := range songs {
+				row := buildSearchRow(song, lyrics[song.ID])
+				if err := tx.Exec("INSERT INTO "+ix.table()+
+					" (song_id, name, singer, video_title, page_title, lyric, pinyin) VALUES (?, ?, ?, ?, ?, ?, ?)",
+					row.SongID, row.Name, row.Singer, row.VideoTitle, row.PageTitle, row.Lyric, row.Pinyin).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// searchTerms folds and splits a keyword into distinct terms.
+func searchTerms(keyword string) []string {
+	var terms []string
+	for _, t := range strings.Fields(searchtext.Fold(keyword)) {
+		if !slices.Contains(terms, t) {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// scoreSearchRow scores a row by the weighted columns each term occurs in.
+func scoreSearchRow(row searchRow, terms []string) float64 {
+	cols := []string{row.Name, row.Singer, row.VideoTitle, row.PageTitle, row.Lyric, row.Pinyin}
+	score := 0.0
+	for _, t := range terms {
+		for i, col := range cols {
+			if strings.Contains(col, t) {
+				score += searchColumnWeights[i].weight
+			}
+		}
+	}
+	// 歌名完全一致或以关键词开头时优先
+	query := strings.Join(terms, " ")
+	if row.Name == query {
+		score += 20
+	} else if strings.HasPrefix(row.Name, query) {
+		score += 10
+	}
+	return score
+}
+
+// querySearchIndex returns the ids and scores of matching songs, best first.
+func (s *Service) querySearchIndex(terms []string, limit int) ([]string, map[string]float64, error) {
+	ix := &s.search
+	// 三元组分词器无法匹配少于 3 个字符的词，这些词改用 LIKE
+	var long, short []string
+	for _, t := range terms {
+		if ix.fts && len([]rune(t)) >= 3 {
+			long = append(long, t)
+		} else {
+			short = append(short, t)
+		}
+	}
+
+	haystack := "(name || ' ' || singer || ' ' || video_title || ' ' || page_title || ' ' || pinyin || ' ' || lyric)"
+	q := s.db.Table(ix.table()).
+		Select("song_id, name, singer, video_title, page_title, lyric, pinyin")
+	for _, t := range short {
+		q = q.Where(haystack+` LIKE ? ESCAPE '\'`, "%"+escapeLike(t)+"%")
+	}
+	var rows []searchRow
+	scores := map[string]float64{}
+	if len(long) > 0 {
+		phrases := make([]string, 0, len(long))
+		for _, t := range long {
+			phrases = append(phrases, `"`+strings.ReplaceAll(t, `"`, `""`)+`"`)
+		}
+		weights := make([]string, 0, len(searchColumnWeights)+1)
+		weights = append(weights, "0")
+		for _, w := range searchColumnWeights {
+			weights = append(weights, fmt.Sprint(w.weight))
+		}
+		var ranked []struct {
+			SongID string
+			Rank   float64
+		}
+		if err := q.Select("song_id, bm25(song_fts, "+strings.Join(weights, ", ")+") AS rank").
+			Where("song_fts MATCH ?", strings.Join(phrases, " ")).
+			Order("rank").Limit(limit).Scan(&ranked).Error; err != nil {
+			return nil, nil, err
+		}
+		ids := make([]string, 0, len(ranked))
+		for _, r := range ranked {
+			ids = append(ids, r.SongID)
+			scores[r.SongID] = -r.Rank
+		}
+		return ids, scores, nil
+	}
+
+	if err := q.Limit(searchScanLimit).Scan(&rows).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, r := range rows {
+		scores[r.SongID] = scoreSearchRow(r, terms)
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return scores[rows[i].SongID] > scores[rows[j].SongID] })
+	ids := make([]string, 0, min(len(rows), limit))
+	for _, r := range rows[:min(len(rows), limit)] {
+		ids = append(ids, r.SongID)
+	}
+	return ids, scores, nil
+}
+
+// lyricSnippet returns the first lyric line containing a term, shortened to
+// searchSnippetRunes around the first match.
+func lyricSnippet(lyric string, terms []string) *SearchSnippet {
+	for _, line := range strings.Split(lyricPlainText(lyric), "\n") {
+		ranges := searchtext.FindAll(line, terms)
+		if len(ranges) == 0 {
+			continue
+		}
+		runes := []rune(strings.TrimSpace(line))
+		lead := len([]rune(line)) - len([]rune(strings.TrimLeft(line, " \t")))
+		start := 0
+		if len(runes) > searchSnippetRunes {
+			start = max(0, min(ranges[0].Start-lead-searchSnippetRunes/4, len(runes)-searchSnippetRunes))
+			runes = runes[start : start+searchSnippetRunes]
+		}
+		out := &SearchSnippet{Field: "lyric", Text: string(runes), Ranges: []searchtext.Range{}}
+		for _, r := range ranges {
+			r.Start -= lead + start
+			r.End -= lead + start
+			if r.End <= 0 || r.Start >= len(runes) {
+				continue
+			}
+			out.Ranges = append(out.Ranges, searchtext.Range{Start: max(r.Start, 0), End: min(r.End, len(runes))})
+		}
+		return out
+	}
+	return nil
+}
+
+// searchSnippets lists the fields of song that contain a term.
+func searchSnippets(song models.Song, lyric string, terms []string) []SearchSnippet {
+	out := []SearchSnippet{}
+	fields := []struct{ name, text string }{
+		{"name", song.Name},
+		{"singer", song.Singer},
+		{"videoTitle", song.VideoTitle},
+		{"pageTitle", song.PageTitle},
+	}
+	for _, f := range fields {
+		if ranges := searchtext.FindAll(f.text, terms); len(ranges) > 0 {
+			out = append(out, SearchSnippet{Field: f.name, Text: f.text, Ranges: ranges})
+		}
+	}
+	if sn := lyricSnippet(lyric, terms); sn != nil {
+		out = append(out, *sn)
+	}
+	if len(out) == 0 {
+		// 只有拼音命中时，展示歌名和歌手的拼音
+		text := songPinyin(song)
+		out = append(out, SearchSnippet{Field: "pinyin", Text: text, Ranges: searchtext.FindAll(text, terms)})
+	}
+	return out
+}
+
+// SearchLibrary searches local songs by name, singer, video and page title,
+// lyrics and the pinyin (or initials) of name and singer. Traditional and
+// simplified Chinese match each other. limit <= 0 means 50.
+func (s *Service) SearchLibrary(keyword string, limit int) ([]SongSearchHit, error) {
+	hits := []SongSearchHit{}
+	terms := searchTerms(keyword)
+	if len(terms) == 0 {
+		return hits, nil
+	}
+	if limit <= 0 {
+		limit = searchDefaultLimit
+	}
+	limit = min(limit, searchMaxLimit)
+
+	if err := s.syncSearchIndex(); err != nil {
+		return nil, err
+	}
+	ids, scores, err := s.querySearchIndex(terms, limit)
+	if err != nil {
+		return nil, fmt.Errorf("搜索失败: %w", err)
+	}
+	if len(ids) == 0 {
+		return hits, nil
+	}
+
+	var songs []models.Song
+	if err := s.db.Where("id IN ?", ids).Find(&songs).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[string]models.Song, len(songs))
+	for _, song := range songs {
+		byID[song.ID] = song
+	}
+	lyrics, err := songLyricTexts(s.db, songs)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		song, ok := byID[id]
+		if !ok {
+			continue
+		}
+		hits = append(hits, SongSearchHit{
+			Song:     song,
+			Score:    scores[id],
+			Snippets: searchSnippets(song, lyrics[id], terms),
+		})
+	}
+	return hits, nil
+}
@@ -110,6 +110,12 @@ func (s *Service) DeleteSong(id string) error {
 		if err := tx.Delete(&models.Song{}, "id = ?", id).Error; err != nil {
 			return err
 		}
+		if err := tx.Delete(&models.SongTag{}, "song_id = ?", id).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.LyricTrack{}, "song_id = ?", id).Error; err != nil {
+			return err
+		}
 
 		// 检查是否有其他歌曲引用此流源
 		var song models.Song
@@ -164,6 +170,13 @@ func (s *Service) DeleteUnreferencedSongs() (int64, error) {
 		}
 		deletedCount = result.RowsAffected
 
+		if err := tx.Where("song_id NOT IN (SELECT id FROM songs)").Delete(&models.SongTag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("song_id NOT IN (SELECT id FROM songs)").Delete(&models.LyricTrack{}).Error; err != nil {
+			return err
+		}
+
 		// 清理未被引用的流源
 		if err := tx.Where("id NOT IN (SELECT DISTINCT source_id FROM songs WHERE source_id IS NOT NULL AND source_id != '')").
 			Delete(&models.StreamSource{}).Error; err != nil {
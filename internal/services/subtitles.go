@@ -0,0 +1,214 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"half-beat-player/internal/lrc"
+	"half-beat-player/internal/models"
+)
+
+// 字幕间隔超过该值时插入空行，避免上一句一直停留在屏幕上
+const subtitleGapMS = 1500
+
+var errNoSubtitle = errors.New("视频没有可用字幕")
+
+// SubtitleTrack is one CC / AI subtitle track of a video page.
+type SubtitleTrack struct {
+	ID      int64  `json:"id"`
+	Lang    string `json:"lang"`    // 如 zh-CN, ai-zh, ja
+	LangDoc string `json:"langDoc"` // 如 中文（中国）
+	URL     string `json:"url"`
+	IsAI    bool   `json:"isAi"`
+}
+
+// SubtitleFillResult summarises a bulk subtitle-to-lyric run over a favorite.
+type SubtitleFillResult struct {
+	Filled  int      `json:"filled"`
+	Skipped int      `json:"skipped"` // 已有歌词或没有字幕
+	Failed  []string `json:"failed"`  // "歌曲名: 错误"
+}
+
+// ListSubtitleTracks lists the subtitle tracks of a video page.
+// AI subtitles are usually only returned for logged-in users.
+func (s *Service) ListSubtitleTracks(bvid string, page int) ([]SubtitleTrack, error) {
+	bvid = extractBVID(bvid)
+	if bvid == "" {
+		return nil, fmt.Errorf("invalid BVID format")
+	}
+	if page <= 0 {
+		page = 1
+	}
+	cid, _, _, err := s.getCidFromBVID(bvid, page)
+	if err != nil {
+		return nil, err
+	}
+	data, err := s.fetchPlayerV2(bvid, cid)
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := make([]SubtitleTrack, 0, len(data.Subtitle.Subtitles))
+	for _, st := range data.Subtitle.Subtitles {
+		if st.SubtitleURL == "" {
+			continue
+		}
+		tracks = append(tracks, SubtitleTrack{
+			ID:      st.ID,
+			Lang:    st.Lan,
+			LangDoc: st.LanDoc,
+			URL:     normalizeBiliPic(st.SubtitleURL),
+			IsAI:    st.AiType > 0 || strings.HasPrefix(st.Lan, "ai-"),
+		})
+	}
+	return tracks, nil
+}
+
+// pickSubtitleTrack chooses the track matching lang, or the best default:
+// human-made before AI, Chinese before other languages.
+func pickSubtitleTrack(tracks []SubtitleTrack, lang string) (SubtitleTrack, bool) {
+	if len(tracks) == 0 {
+		return SubtitleTrack{}, false
+	}
+	if lang != "" {
+		for _, t := range tracks {
+			if strings.EqualFold(t.Lang, lang) {
+				return t, true
+			}
+		}
+		return SubtitleTrack{}, false
+	}
+
+	best, bestScore := tracks[0], -1
+	for _, t := range tracks {
+		score := 0
+		if !t.IsAI {
+			score += 2
+		}
+		if strings.Contains(strings.ToLower(t.Lang), "zh") {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = t, score
+		}
+	}
+	return best, true
+}
+
+// fetchSubtitleLRC downloads a BCC subtitle JSON and converts it to LRC.
+func (s *Service) fetchSubtitleLRC(subtitleURL string) (string, error) {
+	req, err := http.NewRequest("GET", subtitleURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0")
+	req.Header.Set("Referer", "https://www.bilibili.com/")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("subtitle request error: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("subtitle http %d", resp.StatusCode)
+	}
+
+	var bcc struct {
+		Body []struct {
+			From    float64 `json:"from"`
+			To      float64 `json:"to"`
+			Content string  `json:"content"`
+		} `json:"body"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&bcc); err != nil {
+		return "", fmt.Errorf("subtitle decode error: %w", err)
+	}
+	if len(bcc.Body) == 0 {
+		return "", fmt.Errorf("字幕内容为空")
+	}
+
+	doc := lrc.Document{Meta: map[string]string{"by": "bilibili subtitle"}}
+	for i, cue := range bcc.Body {
+		text := strings.Join(strings.Fields(strings.ReplaceAll(cue.Content, "\n", " ")), " ")
+		doc.Lines = append(doc.Lines, lrc.Line{TimeMS: int64(cue.From * 1000), Text: text})
+
+		end := int64(cue.To * 1000)
+		if i+1 < len(bcc.Body) && int64(bcc.Body[i+1].From*1000)-end > subtitleGapMS {
+			doc.Lines = append(doc.Lines, lrc.Line{TimeMS: end})
+		}
+	}
+	return lrc.Format(doc), nil
+}
+
+// ImportSubtitleAsLyric converts a subtitle track of the song's video page to LRC
+// and stores it as the song's lyric. lang may be empty to pick the best track.
+func (s *Service) ImportSubtitleAsLyric(songID string, lang string) (models.LyricMapping, error) {
+	song, err := s.getSongByID(songID)
+	if err != nil {
+		return models.LyricMapping{}, err
+	}
+	return s.importSubtitleForSong(song, lang)
+}
+
+func (s *Service) importSubtitleForSong(song models.Song, lang string) (models.LyricMapping, error) {
+	if song.BVID == "" {
+		return models.LyricMapping{}, fmt.Errorf("歌曲缺少 BVID")
+	}
+	tracks, err := s.ListSubtitleTracks(song.BVID, song.PageNumber)
+	if err != nil {
+		return models.LyricMapping{}, err
+	}
+	track, ok := pickSubtitleTrack(tracks, lang)
+	if !ok {
+		return models.LyricMapping{}, errNoSubtitle
+	}
+	text, err := s.fetchSubtitleLRC(track.URL)
+	if err != nil {
+		return models.LyricMapping{}, err
+	}
+
+	m, err := s.GetLyricMapping(song.ID)
+	if err != nil {
+		return models.LyricMapping{}, err
+	}
+	m.Lyric = text
+	if err := s.SaveLyricMapping(m); err != nil {
+		return models.LyricMapping{}, err
+	}
+	return m, nil
+}
+
+// FillFavoriteLyricsFromSubtitles imports subtitles as lyrics for every song
+// in the favorite that has no lyrics yet.
+func (s *Service) FillFavoriteLyricsFromSubtitles(favoriteID string, lang string) (SubtitleFillResult, error) {
+	songs, err := s.getFavoriteSongs(favoriteID)
+	if err != nil {
+		return SubtitleFillResult{}, err
+	}
+
+	res := SubtitleFillResult{Failed: []string{}}
+	for _, song := range songs {
+		text, _, err := s.getSongLyricText(song.ID)
+		if err != nil {
+			res.Failed = append(res.Failed, fmt.Sprintf("%s: %v", song.Name, err))
+			continue
+		}
+		if strings.TrimSpace(text) != "" || song.BVID == "" {
+			res.Skipped++
+			continue
+		}
+		if _, err := s.importSubtitleForSong(song, lang); err != nil {
+			if errors.Is(err, errNoSubtitle) {
+				res.Skipped++
+				continue
+			}
+			res.Failed = append(res.Failed, fmt.Sprintf("%s: %v", song.Name, err))
+			continue
+		}
+		res.Filled++
+	}
+	return res, nil
+}
@@ -0,0 +1,229 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"half-beat-player/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// TagCount reports how many songs and favorites carry a tag.
+type TagCount struct {
+	Tag       string `json:"tag"`
+	Songs     int    `json:"songs"`
+	Favorites int    `json:"favorites"`
+}
+
+// normaliseTags trims tags and drops empty and case-insensitive duplicates.
+func normaliseTags(tags []string) []string {
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		t = strings.Join(strings.Fields(t), " ")
+		key := strings.ToLower(t)
+		if t == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, t)
+	}
+	return out
+}
+
+// SetFavoriteTags replaces the tags of a favorite and returns the stored tags.
+func (s *Service) SetFavoriteTags(favoriteID string, tags []string) ([]string, error) {
+	tags = normaliseTags(tags)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Favorite{}).Where("id = ?", favoriteID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("歌单不存在: %s", favoriteID)
+		}
+		if err := tx.Where("favorite_id = ?", favoriteID).Delete(&models.FavoriteTag{}).Error; err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			return nil
+		}
+		rows := make([]models.FavoriteTag, 0, len(tags))
+		for _, t := range tags {
+			rows = append(rows, models.FavoriteTag{FavoriteID: favoriteID, Tag: t})
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// SetSongTags replaces the tags of a song and returns the stored tags.
+func (s *Service) SetSongTags(songID string, tags []string) ([]string, error) {
+	tags = normaliseTags(tags)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.Song{}).Where("id = ?", songID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count == 0 {
+			return fmt.Errorf("未找到歌曲: %s", songID)
+		}
+		if err := tx.Where("song_id = ?", songID).Delete(&models.SongTag{}).Error; err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			return nil
+		}
+		rows := make([]models.SongTag, 0, len(tags))
+		for _, t := range tags {
+			rows = append(rows, models.SongTag{SongID: songID, Tag: t})
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// TagSongs adds tags to many songs at once, keeping their existing tags.
+func (s *Service) TagSongs(songIDs []string, tags []string) error {
+	tags = normaliseTags(tags)
+	if len(songIDs) == 0 || len(tags) == 0 {
+		return nil
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var existing []models.SongTag
+		if err := tx.Where("song_id IN ?", songIDs).Find(&existing).Error; err != nil {
+			return err
+		}
+		has := make(map[string]bool, len(existing))
+		for _, t := range existing {
+			has[t.SongID+"\x00"+strings.ToLower(t.Tag)] = true
+		}
+		rows := []models.SongTag{}
+		for _, id := range songIDs {
+			for _, t := range tags {
+				key := id + "\x00" + strings.ToLower(t)
+				if has[key] {
+					continue
+				}
+				has[key] = true
+				rows = append(rows, models.SongTag{SongID: id, Tag: t})
+			}
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		return tx.Create(&rows).Error
+	})
+}
+
+// GetSongTags returns the tags of a song.
+func (s *Service) GetSongTags(songID string) ([]string, error) {
+	tags := []string{}
+	if err := s.db.Model(&models.SongTag{}).Where("song_id = ?", songID).
+		Order("tag COLLATE NOCASE").Pluck("tag", &tags).Error; err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// favoriteTagMap returns favorite id -> tags.
+func (s *Service) favoriteTagMap() (map[string][]string, error) {
+	var rows []models.FavoriteTag
+	if err := s.db.Order("tag COLLATE NOCASE").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	out := make(map[string][]string, len(rows))
+	for _, r := range rows {
+		out[r.FavoriteID] = append(out[r.FavoriteID], r.Tag)
+	}
+	return out, nil
+}
+
+// ListTags returns every tag in use with song and favorite counts.
+func (s *Service) ListTags() ([]TagCount, error) {
+	byKey := map[string]*TagCount{}
+	add := func(tag string, song bool) {
+		key := strings.ToLower(tag)
+		tc, ok := byKey[key]
+		if !ok {
+			tc = &TagCount{Tag: tag}
+			byKey[key] = tc
+		}
+		if song {
+			tc.Songs++
+		} else {
+			tc.Favorites++
+		}
+	}
+
+	var songTags []models.SongTag
+	if err := s.db.Find(&songTags).Error; err != nil {
+		return nil, err
+	}
+	for _, t := range songTags {
+		add(t.Tag, true)
+	}
+	var favTags []models.FavoriteTag
+	if err := s.db.Find(&favTags).Error; err != nil {
+		return nil, err
+	}
+	for _, t := range favTags {
+		add(t.Tag, false)
+	}
+
+	out := make([]TagCount, 0, len(byKey))
+	for _, tc := range byKey {
+		out = append(out, *tc)
+	}
+	sort.Slice(out, func(i, j int) bool { return strings.ToLower(out[i].Tag) < strings.ToLower(out[j].Tag) })
+	return out, nil
+}
+
+// tagFilter builds "<idColumn> IN (...)" for rows carrying any or all of tags.
+func tagFilter(table, idColumn string, tags []string, matchAll bool) (string, []any) {
+	lower := make([]string, 0, len(tags))
+	for _, t := range tags {
+		lower = append(lower, strings.ToLower(t))
+	}
+	sub := fmt.Sprintf("SELECT %s FROM %s WHERE LOWER(tag) IN ? GROUP BY %s", idColumn, table, idColumn)
+	args := []any{lower}
+	if matchAll {
+		sub += " HAVING COUNT(DISTINCT LOWER(tag)) = ?"
+		args = append(args, len(lower))
+	}
+	return sub, args
+}
+
+// FindSongsByTags returns songs tagged with any (or, with matchAll, every) of tags.
+func (s *Service) FindSongsByTags(tags []string, matchAll bool) ([]models.Song, error) {
+	tags = normaliseTags(tags)
+	songs := []models.Song{}
+	if len(tags) == 0 {
+		return songs, nil
+	}
+	sub, args := tagFilter("song_tags", "song_id", tags, matchAll)
+	if err := s.db.Where("id IN ("+sub+")", args...).Order("name").Find(&songs).Error; err != nil {
+		return nil, err
+	}
+	return songs, nil
+}
+
+// FindFavoritesByTags returns favorites tagged with any (or, with matchAll, every) of tags.
+func (s *Service) FindFavoritesByTags(tags []string, matchAll bool) ([]FavoriteSummary, error) {
+	tags = normaliseTags(tags)
+	if len(tags) == 0 {
+		return []FavoriteSummary{}, nil
+	}
+	sub, args := tagFilter("favorite_tags", "favorite_id", tags, matchAll)
+	return s.listFavoriteSummaries(func(db *gorm.DB) *gorm.DB {
+		return db.Where("id IN ("+sub+")", args...)
+	})
+}
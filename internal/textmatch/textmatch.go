@@ -0,0 +1,78 @@
+// Package textmatch provides the fuzzy text helpers used to match songs,
+// lyrics and files by title and singer.
+package textmatch
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// 视频标题中常见的修饰信息：【...】、[...]、(...)、「」《》 等
+var decorationRe = regexp.MustCompile(`【[^】]*】|\[[^\]]*\]|（[^）]*）|\([^)]*\)|「|」|《|》|『|』`)
+
+// CleanTitle strips decorations commonly found in video titles, such as
+// "【中字】", "[MV]" or "(Live)", keeping the core song name.
+func CleanTitle(title string) string {
+	cleaned := strings.TrimSpace(decorationRe.ReplaceAllString(title, " "))
+	if cleaned == "" {
+		return strings.TrimSpace(title)
+	}
+	return strings.Join(strings.Fields(cleaned), " ")
+}
+
+// Normalize lowercases s and drops punctuation and whitespace so that
+// "Hello, World!" and "hello world" compare equal.
+func Normalize(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Similarity returns a 0..1 score for how alike a and b are after
+// normalisation. Containment of one string in the other scores highly.
+func Similarity(a, b string) float64 {
+	na, nb := []rune(Normalize(a)), []rune(Normalize(b))
+	if len(na) == 0 || len(nb) == 0 {
+		return 0
+	}
+	if string(na) == string(nb) {
+		return 1
+	}
+
+	longer := max(len(na), len(nb))
+	ratio := 1 - float64(levenshtein(na, nb))/float64(longer)
+
+	shorter := min(len(na), len(nb))
+	if shorter >= 2 && (strings.Contains(string(na), string(nb)) || strings.Contains(string(nb), string(na))) {
+		contain := 0.7 + 0.3*float64(shorter)/float64(longer)
+		if contain > ratio {
+			ratio = contain
+		}
+	}
+	return ratio
+}
+
+func levenshtein(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
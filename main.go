@@ -57,11 +57,17 @@ func run() error {
 			&models.Song{},
 			&models.Favorite{},
 			&models.SongRef{},
+			&models.FavoriteFolder{},
+			&models.FavoriteTag{},
+			&models.SongTag{},
+			&models.BiliFavoriteLink{},
 			&models.PlayerSetting{},
 			&models.LyricMapping{},
+			&models.LyricTrack{},
 			&models.Playlist{},
 			&models.LoginSession{},
 			&models.PlayHistory{},
+			&models.AudioLoudness{},
 		); err != nil {
 			return err
 		}
@@ -71,6 +77,19 @@ func run() error {
 				return err
 			}
 		}
+		// 旧数据库的 song_refs 没有 position/added_at，按插入顺序（id）补齐
+		if err := gdb.Exec(`UPDATE song_refs SET position = 1024 * (
+			SELECT COUNT(*) FROM song_refs r2 WHERE r2.favorite_id = song_refs.favorite_id AND r2.id <= song_refs.id
+		) WHERE favorite_id IN (
+			SELECT favorite_id FROM song_refs GROUP BY favorite_id HAVING MAX(COALESCE(position, 0)) = 0
+		)`).Error; err != nil {
+			return err
+		}
+		if err := gdb.Exec(`UPDATE song_refs SET added_at = COALESCE(
+			(SELECT created_at FROM favorites WHERE favorites.id = song_refs.favorite_id), CURRENT_TIMESTAMP
+		) WHERE added_at IS NULL`).Error; err != nil {
+			return err
+		}
 		return nil
 	})
 	if err != nil {